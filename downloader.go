@@ -3,17 +3,15 @@ package main
 import (
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path"
-	"path/filepath"
-	"strings"
+
+	"img-scraper/internal"
 )
 
 // Download fetches a file from the given URL and saves it to the output directory.
 func Download(rawurl, outdir string) error {
-	resp, err := http.Get(rawurl)
+	resp, err := internal.NewClient().Get(rawurl)
 	if err != nil {
 		return fmt.Errorf("download error: %w", err)
 	}
@@ -21,27 +19,28 @@ func Download(rawurl, outdir string) error {
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
 		return fmt.Errorf("bad status for %s: %s", rawurl, resp.Status)
 	}
-	u, err := url.Parse(rawurl)
-	if err != nil {
-		return fmt.Errorf("parse url err: %w", err)
-	}
-	fname := path.Base(u.Path)
-	if fname == "" || fname == "." || strings.Contains(fname, "/") {
-		fname = "file_" + strings.ReplaceAll(strings.ReplaceAll(u.Host+u.Path, "/", "_"), "?", "_")
-	}
-	fname = filepath.Join(outdir, fname)
-	if _, err := os.Stat(fname); err == nil {
-		fname = fname + "_1"
+	ext := path.Ext(resp.Request.URL.Path)
+	if len(ext) > 10 {
+		ext = ""
 	}
-	f, err := os.Create(fname)
+	f, err := os.CreateTemp(outdir, ".download-*.tmp")
 	if err != nil {
 		return fmt.Errorf("create file err: %w", err)
 	}
-	defer f.Close()
 	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		os.Remove(f.Name())
 		return fmt.Errorf("write file err: %w", err)
 	}
+	// Name it like every other download; a taken name is resolved by content, not by a _1 suffix.
+	fname, err := internal.StoreFile(f.Name(), outdir, internal.NameVars{URL: rawurl, Ext: ext})
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("save file err: %w", err)
+	}
 	fmt.Println("Saved:", fname)
 	return nil
 }
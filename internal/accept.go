@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// inspectLen is how much of the start of a download is read before anything is written, enough
+// for image.DecodeConfig to find the dimensions of nearly every image.
+const inspectLen = 64 << 10
+
+// AcceptFilter decides which downloads are kept. Zero fields are not checked.
+type AcceptFilter struct {
+	MinWidth  int `json:"min_width,omitempty"`
+	MinHeight int `json:"min_height,omitempty"`
+	MaxWidth  int `json:"max_width,omitempty"`
+	MaxHeight int `json:"max_height,omitempty"`
+	// MinAspect and MaxAspect bound width/height, e.g. 0.25 and 4 drop banners and strips.
+	MinAspect float64 `json:"min_aspect,omitempty"`
+	MaxAspect float64 `json:"max_aspect,omitempty"`
+	MinBytes  int64   `json:"min_bytes,omitempty"`
+	MaxBytes  int64   `json:"max_bytes,omitempty"`
+}
+
+// DefaultAcceptFilter keeps the historical rule: nothing under 10KB.
+var DefaultAcceptFilter = AcceptFilter{MinBytes: 10 << 10}
+
+// Rejection is a download turned down by the accept filter, as recorded in the job.
+type Rejection struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+var (
+	acceptFilterMu sync.RWMutex
+	acceptFilter   = DefaultAcceptFilter
+)
+
+// SetAcceptFilter replaces the filter applied to every download.
+func SetAcceptFilter(f AcceptFilter) {
+	acceptFilterMu.Lock()
+	acceptFilter = f
+	acceptFilterMu.Unlock()
+}
+
+func currentAcceptFilter() AcceptFilter {
+	acceptFilterMu.RLock()
+	defer acceptFilterMu.RUnlock()
+	return acceptFilter
+}
+
+// checkSize rejects a download of rawurl whose total size is known and out of bounds.
+func (f AcceptFilter) checkSize(rawurl string, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	if f.MinBytes > 0 && size < f.MinBytes {
+		return &TooSmallError{URL: rawurl, Size: size, Min: f.MinBytes}
+	}
+	if f.MaxBytes > 0 && size > f.MaxBytes {
+		return &FilteredError{URL: rawurl, Reason: fmt.Sprintf("%d bytes is over the %d byte maximum", size, f.MaxBytes)}
+	}
+	return nil
+}
+
+// checkImage rejects by the dimensions in an image header. Files that are not images, or whose
+// format has no decoder here (AVIF, HEIC, SVG), pass.
+func (f AcceptFilter) checkImage(head []byte) string {
+	if f.MinWidth == 0 && f.MinHeight == 0 && f.MaxWidth == 0 && f.MaxHeight == 0 && f.MinAspect == 0 && f.MaxAspect == 0 {
+		return ""
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return ""
+	}
+	w, h := cfg.Width, cfg.Height
+	aspect := float64(w) / float64(h)
+	switch {
+	case f.MinWidth > 0 && w < f.MinWidth:
+		return fmt.Sprintf("width %d is under the minimum %d", w, f.MinWidth)
+	case f.MinHeight > 0 && h < f.MinHeight:
+		return fmt.Sprintf("height %d is under the minimum %d", h, f.MinHeight)
+	case f.MaxWidth > 0 && w > f.MaxWidth:
+		return fmt.Sprintf("width %d is over the maximum %d", w, f.MaxWidth)
+	case f.MaxHeight > 0 && h > f.MaxHeight:
+		return fmt.Sprintf("height %d is over the maximum %d", h, f.MaxHeight)
+	case f.MinAspect > 0 && aspect < f.MinAspect:
+		return fmt.Sprintf("aspect ratio %.2f (%dx%d) is under the minimum %.2f", aspect, w, h, f.MinAspect)
+	case f.MaxAspect > 0 && aspect > f.MaxAspect:
+		return fmt.Sprintf("aspect ratio %.2f (%dx%d) is over the maximum %.2f", aspect, w, h, f.MaxAspect)
+	}
+	return ""
+}
+
+// inspection is the result of looking at the start of a download before writing it.
+type inspection struct {
+	Type FileType
+	body io.Reader // the response body, with the inspected bytes still unread
+}
+
+// inspect reads the start of a response (or, when resuming, of the part file plus the new bytes)
+// and applies type detection and the accept filter before anything is written. size is the total
+// length if known, else -1.
+func (d *resumable) inspect(body io.Reader, contentType string, size int64) (*inspection, error) {
+	br := bufio.NewReaderSize(body, inspectLen)
+	var head []byte
+	if d.offset > 0 {
+		if f, err := os.Open(d.part); err == nil {
+			head, _ = io.ReadAll(io.LimitReader(f, inspectLen))
+			f.Close()
+		}
+	}
+	if len(head) < inspectLen {
+		peek, _ := br.Peek(inspectLen - len(head))
+		head = append(head, peek...)
+	}
+	ins, err := inspectBytes(d.state.URL, head, contentType, size)
+	if err != nil {
+		return nil, err
+	}
+	ins.body = br
+	if max := currentAcceptFilter().MaxBytes; max > 0 {
+		// A server that did not announce the size is cut off once it passes the maximum.
+		ins.body = &maxBytesReader{r: br, left: max - d.offset, url: d.state.URL, max: max}
+	}
+	return ins, nil
+}
+
+// inspectBytes applies type detection and the accept filter to the first bytes of a download.
+func inspectBytes(rawurl string, head []byte, contentType string, size int64) (*inspection, error) {
+	ft, err := detectType(head[:min(len(head), sniffLen)], contentType, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	f := currentAcceptFilter()
+	if err := f.checkSize(rawurl, size); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(ft.MIME, "image/") {
+		if reason := f.checkImage(head); reason != "" {
+			return nil, &FilteredError{URL: rawurl, Reason: reason}
+		}
+	}
+	return &inspection{Type: ft}, nil
+}
+
+// maxBytesReader fails with a FilteredError once more than max bytes have been read.
+type maxBytesReader struct {
+	r    io.Reader
+	left int64
+	url  string
+	max  int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.left < 0 {
+		return 0, &FilteredError{URL: m.url, Reason: fmt.Sprintf("over the %d byte maximum", m.max)}
+	}
+	if int64(len(p)) > m.left+1 {
+		p = p[:m.left+1]
+	}
+	n, err := m.r.Read(p)
+	m.left -= int64(n)
+	if m.left < 0 {
+		return n, &FilteredError{URL: m.url, Reason: fmt.Sprintf("over the %d byte maximum", m.max)}
+	}
+	return n, err
+}
+
+// checkSize applies the byte limits to the finished part file, for servers that did not
+// announce a length up front.
+func (d *resumable) checkSize() error {
+	return currentAcceptFilter().checkSize(d.state.URL, d.size())
+}
+
+// isFinal reports whether err rules the download out for good, so retrying is pointless.
+func isFinal(err error) bool {
+	var (
+		filteredErr *FilteredError
+		smallErr    *TooSmallError
+		decodeErr   *DecodeError
+	)
+	return errors.As(err, &filteredErr) || errors.As(err, &smallErr) || (errors.As(err, &decodeErr) && decodeErr.MIME != "")
+}
+
+// rejectionFor turns a final refusal into the record kept in the job.
+func rejectionFor(rawurl string, err error) (Rejection, bool) {
+	var fe *FilteredError
+	if errors.As(err, &fe) {
+		return Rejection{URL: rawurl, Reason: fe.Reason}, true
+	}
+	var small *TooSmallError
+	if errors.As(err, &small) {
+		return Rejection{URL: rawurl, Reason: fmt.Sprintf("%d bytes is under the %d byte minimum", small.Size, small.Min)}, true
+	}
+	var de *DecodeError
+	if errors.As(err, &de) && de.MIME != "" {
+		return Rejection{URL: rawurl, Reason: fmt.Sprintf("got %s instead of media", de.MIME)}, true
+	}
+	return Rejection{}, false
+}
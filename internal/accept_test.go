@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testPNG encodes a w x h PNG.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withAcceptFilter applies f for the length of a test.
+func withAcceptFilter(t *testing.T, f AcceptFilter) {
+	t.Helper()
+	SetAcceptFilter(f)
+	t.Cleanup(func() { SetAcceptFilter(DefaultAcceptFilter) })
+}
+
+func TestAcceptFilterCheckSize(t *testing.T) {
+	f := AcceptFilter{MinBytes: 100, MaxBytes: 1000}
+	tests := []struct {
+		size int64
+		want string // "", "small" or "filtered"
+	}{
+		{-1, ""},
+		{99, "small"},
+		{100, ""},
+		{1000, ""},
+		{1001, "filtered"},
+	}
+	for _, tt := range tests {
+		err := f.checkSize("http://x/a.jpg", tt.size)
+		var small *TooSmallError
+		var filtered *FilteredError
+		got := ""
+		switch {
+		case errors.As(err, &small):
+			got = "small"
+		case errors.As(err, &filtered):
+			got = "filtered"
+		case err != nil:
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("checkSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptFilterCheckImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter AcceptFilter
+		w, h   int
+		want   string // substring of the reason, "" to accept
+	}{
+		{"no limits", AcceptFilter{}, 10, 10, ""},
+		{"wide enough", AcceptFilter{MinWidth: 100}, 100, 10, ""},
+		{"too narrow", AcceptFilter{MinWidth: 100}, 99, 10, "width 99 is under the minimum 100"},
+		{"too short", AcceptFilter{MinHeight: 50}, 10, 49, "height 49 is under the minimum 50"},
+		{"too wide", AcceptFilter{MaxWidth: 200}, 201, 10, "width 201 is over the maximum 200"},
+		{"too tall", AcceptFilter{MaxHeight: 200}, 10, 201, "height 201 is over the maximum 200"},
+		{"banner", AcceptFilter{MaxAspect: 4}, 500, 100, "aspect ratio 5.00 (500x100) is over the maximum 4.00"},
+		{"strip", AcceptFilter{MinAspect: 0.25}, 10, 100, "aspect ratio 0.10 (10x100) is under the minimum 0.25"},
+		{"square within aspect", AcceptFilter{MinAspect: 0.25, MaxAspect: 4}, 80, 80, ""},
+	}
+	for _, tt := range tests {
+		got := tt.filter.checkImage(testPNG(t, tt.w, tt.h))
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: checkImage(%dx%d) = %q, want %q", tt.name, tt.w, tt.h, got, tt.want)
+		}
+	}
+	// Bytes that are not a decodable image pass: there is nothing to measure.
+	if got := (AcceptFilter{MinWidth: 100}).checkImage([]byte("not an image")); got != "" {
+		t.Errorf("checkImage(garbage) = %q, want accepted", got)
+	}
+}
+
+func TestInspectBytes(t *testing.T) {
+	withAcceptFilter(t, AcceptFilter{MinBytes: 10, MinWidth: 50})
+	big, small := testPNG(t, 60, 60), testPNG(t, 20, 20)
+	tests := []struct {
+		name     string
+		head     []byte
+		ctype    string
+		size     int64
+		wantExt  string
+		rejected bool
+	}{
+		{"image", big, "image/png", int64(len(big)), ".png", false},
+		{"image under octet-stream", big, "application/octet-stream", -1, ".png", false},
+		{"narrow image", small, "image/png", int64(len(small)), "", true},
+		{"error page", []byte("<!DOCTYPE html><html><body>Not found</body></html>"), "text/html", 50, "", true},
+		{"too small", big[:8], "image/png", 8, "", true},
+	}
+	for _, tt := range tests {
+		ins, err := inspectBytes("http://x/a.png", tt.head, tt.ctype, tt.size)
+		if tt.rejected {
+			if err == nil || !isFinal(err) {
+				t.Errorf("%s: err = %v, want a final rejection", tt.name, err)
+			} else if _, ok := rejectionFor("http://x/a.png", err); !ok {
+				t.Errorf("%s: %v is not recorded as a rejection", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if ins.Type.Ext != tt.wantExt {
+			t.Errorf("%s: ext = %q, want %q", tt.name, ins.Type.Ext, tt.wantExt)
+		}
+	}
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	tests := []struct {
+		n, max  int
+		wantErr bool
+	}{
+		{99, 100, false},
+		{100, 100, false},
+		{101, 100, true},
+		{5000, 100, true},
+	}
+	for _, tt := range tests {
+		r := &maxBytesReader{r: bytes.NewReader(testBody(tt.n)), left: int64(tt.max), url: "http://x/a", max: int64(tt.max)}
+		got, err := io.ReadAll(r)
+		var filtered *FilteredError
+		if tt.wantErr != errors.As(err, &filtered) {
+			t.Errorf("%d bytes under a %d maximum: err = %v", tt.n, tt.max, err)
+		}
+		if len(got) > tt.max+1 {
+			t.Errorf("%d bytes under a %d maximum: read %d", tt.n, tt.max, len(got))
+		}
+	}
+}
+
+func TestSaveCapturedMedia(t *testing.T) {
+	withAcceptFilter(t, AcceptFilter{MinWidth: 50})
+	outDir := t.TempDir()
+	media := []CapturedMedia{
+		{URL: "http://x/ok.png", Origin: OriginNetwork, MIMEType: "image/png", Body: testPNG(t, 60, 60)},
+		{URL: "http://x/thumb.png", Origin: OriginNetwork, MIMEType: "image/png", Body: testPNG(t, 10, 10)},
+		{URL: "blob:http://x/1", Origin: OriginBlob, MIMEType: "image/png", Body: []byte("<html><body>oops</body></html>")},
+		{URL: "http://x/evicted.png", Origin: OriginNetwork, MIMEType: "image/png"},
+	}
+	saved, results, err := SaveCapturedMedia(media, outDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].URL != "http://x/ok.png" {
+		t.Fatalf("saved = %+v, want only ok.png", saved)
+	}
+	if _, err := os.Stat(saved[0].Path); err != nil {
+		t.Errorf("saved file: %v", err)
+	}
+	want := []struct{ url, status string }{
+		{"http://x/ok.png", DownloadOK},
+		{"http://x/thumb.png", DownloadRejected},
+		{"blob:http://x/1", DownloadRejected},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d (bodiless media is not reported)", len(results), len(want))
+	}
+	for i, w := range want {
+		if results[i].URL != w.url || results[i].Status != w.status || results[i].Method != MethodBrowser {
+			t.Errorf("result %d = %+v, want %s %s via browser", i, results[i], w.url, w.status)
+		}
+	}
+	if results[0].File != relFile(outDir, saved[0].Path) || results[0].Bytes != int64(len(media[0].Body)) {
+		t.Errorf("result %+v does not describe %s", results[0], saved[0].Path)
+	}
+	entries, _ := os.ReadDir(outDir)
+	if len(entries) != 1 {
+		t.Errorf("%d files in the output directory, want only the accepted one", len(entries))
+	}
+	var batch BatchResult
+	batch.Merge(results)
+	if batch.Succeeded != 1 || batch.Rejected != 2 || len(batch.Rejections()) != 2 {
+		t.Errorf("merged batch: %d ok, %d rejected, %d rejections; want 1, 2, 2", batch.Succeeded, batch.Rejected, len(batch.Rejections()))
+	}
+}
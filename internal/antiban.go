@@ -13,14 +13,26 @@ var userAgents = []string{
 	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/115.0.0.0 Safari/537.36",
 }
 
-// NewClient returns an http.Client with a random User-Agent and optional proxy.
+// NewClient returns an http.Client on the shared transport, so its connections are pooled and reused.
 func NewClient() *http.Client {
 	client := &http.Client{
-		Timeout: 60 * time.Second,
+		Timeout:   60 * time.Second,
+		Transport: HTTPTransport(),
 	}
 	return client
 }
 
+// readIdleTimeout is how long a download body may go without delivering a byte.
+const readIdleTimeout = 60 * time.Second
+
+// newDownloadClient returns a client for media downloads over rt. It has no overall timeout:
+// that would also count the time bandwidth throttling sleeps between reads and cut off long
+// capped downloads. The transport's ResponseHeaderTimeout bounds the wait for a response and
+// the body fails once the connection stalls for readIdleTimeout.
+func newDownloadClient(jar http.CookieJar, rt http.RoundTripper) *http.Client {
+	return &http.Client{Transport: idleTimeoutTransport{base: rt, idle: readIdleTimeout}, Jar: jar}
+}
+
 // RandomUserAgent returns a random User-Agent string.
 var randOnce sync.Once
 
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a throttled read takes at once, so waits stay short and smooth.
+const throttleChunk = 32 << 10
+
+// BandwidthLimit caps download speed in bytes per second. Zero is unlimited.
+type BandwidthLimit struct {
+	Global  int64 `json:"global"`   // all downloads of the process together
+	PerHost int64 `json:"per_host"` // each host, unless listed in Hosts
+	// Hosts overrides PerHost for single hosts, by host name.
+	Hosts map[string]int64 `json:"hosts,omitempty"`
+}
+
+// byteBucket is a token bucket counted in bytes, allowed to go into debt: a read takes its
+// bytes at once and then waits until the bucket is back at zero.
+type byteBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n bytes at rate and returns how long to wait for them.
+func (b *byteBucket) reserve(rate float64, n int, now time.Time) time.Duration {
+	if rate != b.rate {
+		b.rate, b.tokens = rate, min(b.tokens, rate)
+	}
+	b.tokens = min(rate, b.tokens+now.Sub(b.last).Seconds()*rate) // one second of burst
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// bandwidthLimiter holds the buckets shared by every download in the process.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	limit  BandwidthLimit
+	global byteBucket
+	hosts  map[string]*byteBucket
+}
+
+var bandwidth = &bandwidthLimiter{hosts: make(map[string]*byteBucket)}
+
+// SetBandwidthLimit changes the caps; downloads already running slow down or speed up on their
+// next read.
+func SetBandwidthLimit(l BandwidthLimit) {
+	bandwidth.mu.Lock()
+	bandwidth.limit = l
+	bandwidth.mu.Unlock()
+}
+
+// CurrentBandwidthLimit returns the caps in force.
+func CurrentBandwidthLimit() BandwidthLimit {
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	return bandwidth.limit
+}
+
+// wait blocks until n bytes from host fit under the global and per-host caps.
+func (l *bandwidthLimiter) wait(host string, n int) {
+	if d := l.reserve(host, n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// reserve takes n bytes from host out of the buckets and returns how long to wait for them.
+func (l *bandwidthLimiter) reserve(host string, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var d time.Duration
+	if l.limit.Global > 0 {
+		d = l.global.reserve(float64(l.limit.Global), n, now)
+	}
+	if rate := l.hostRate(host); rate > 0 {
+		b, ok := l.hosts[host]
+		if !ok {
+			b = &byteBucket{rate: float64(rate), tokens: float64(rate), last: now}
+			l.hosts[host] = b
+		}
+		d = max(d, b.reserve(float64(rate), n, now))
+	}
+	return d
+}
+
+// hostRate is the per-host cap for host; the caller holds l.mu.
+func (l *bandwidthLimiter) hostRate(host string) int64 {
+	if rate, ok := l.limit.Hosts[host]; ok {
+		return rate
+	}
+	return l.limit.PerHost
+}
+
+// rateFor returns the tightest cap that applies to a download from host, or 0 if none does.
+func (l *bandwidthLimiter) rateFor(host string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := l.limit.Global
+	if h := l.hostRate(host); h > 0 && (rate == 0 || h < rate) {
+		rate = h
+	}
+	return rate
+}
+
+// throttledReader paces reads from a response body to the bandwidth caps.
+type throttledReader struct {
+	r    io.Reader
+	host string
+}
+
+// throttle wraps a response body from host so it is read no faster than the caps allow.
+func throttle(host string, r io.Reader) io.Reader {
+	return &throttledReader{r: r, host: host}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		bandwidth.wait(t.host, n)
+	}
+	return n, err
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestByteBucketReserve(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		rate  float64
+		reads []int           // bytes per read, all at start+after
+		after []time.Duration // time of each read
+		want  []time.Duration // wait for each read
+	}{
+		{"within burst", 1000, []int{400, 600}, []time.Duration{0, 0}, []time.Duration{0, 0}},
+		{"into debt", 1000, []int{1000, 500}, []time.Duration{0, 0}, []time.Duration{0, 500 * time.Millisecond}},
+		{"refills over time", 1000, []int{1000, 500}, []time.Duration{0, 500 * time.Millisecond}, []time.Duration{0, 0}},
+		{"burst is one second", 1000, []int{1000, 1500}, []time.Duration{0, 10 * time.Second}, []time.Duration{0, 500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		b := &byteBucket{rate: tt.rate, tokens: tt.rate, last: start}
+		for i, n := range tt.reads {
+			if got := b.reserve(tt.rate, n, start.Add(tt.after[i])); got != tt.want[i] {
+				t.Errorf("%s: read %d of %d bytes waits %v, want %v", tt.name, i, n, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestByteBucketRateChange(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &byteBucket{rate: 1 << 20, tokens: 1 << 20, last: now}
+	// Lowering the cap must not leave the old, larger burst behind.
+	if got := b.reserve(1000, 1500, now); got != 500*time.Millisecond {
+		t.Errorf("after lowering the rate: wait %v, want 500ms", got)
+	}
+}
+
+func TestThrottledRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit BandwidthLimit
+		size  int
+		min   time.Duration
+	}{
+		{"unlimited", BandwidthLimit{}, 200 << 10, 0},
+		{"global", BandwidthLimit{Global: 100 << 10}, 150 << 10, 400 * time.Millisecond},
+		{"per host", BandwidthLimit{PerHost: 100 << 10}, 150 << 10, 400 * time.Millisecond},
+		{"host override", BandwidthLimit{PerHost: 1, Hosts: map[string]int64{"fast.test": 0}}, 200 << 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Fresh buckets, so one case's debt does not slow the next.
+			saved := bandwidth
+			bandwidth = &bandwidthLimiter{hosts: make(map[string]*byteBucket)}
+			t.Cleanup(func() { bandwidth = saved })
+			SetBandwidthLimit(tt.limit)
+			start := time.Now()
+			n, err := io.Copy(io.Discard, throttle("fast.test", bytes.NewReader(make([]byte, tt.size))))
+			elapsed := time.Since(start)
+			if err != nil || n != int64(tt.size) {
+				t.Fatalf("read %d bytes, err %v", n, err)
+			}
+			if elapsed < tt.min {
+				t.Errorf("%d bytes took %v, want at least %v", tt.size, elapsed, tt.min)
+			}
+			if tt.min == 0 && elapsed > 200*time.Millisecond {
+				t.Errorf("unthrottled read took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestBandwidthRateFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit BandwidthLimit
+		want  int64
+	}{
+		{"unlimited", BandwidthLimit{}, 0},
+		{"global only", BandwidthLimit{Global: 500}, 500},
+		{"per host below global", BandwidthLimit{Global: 500, PerHost: 200}, 200},
+		{"global below per host", BandwidthLimit{Global: 100, PerHost: 200}, 100},
+		{"host override", BandwidthLimit{PerHost: 200, Hosts: map[string]int64{"a.test": 50}}, 50},
+		{"host override unlimited", BandwidthLimit{PerHost: 200, Hosts: map[string]int64{"a.test": 0}}, 0},
+	}
+	for _, tt := range tests {
+		l := &bandwidthLimiter{limit: tt.limit, hosts: make(map[string]*byteBucket)}
+		if got := l.rateFor("a.test"); got != tt.want {
+			t.Errorf("%s: rateFor = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,25 +2,142 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
 
+// RenderOptions controls what RenderPageWithOptions collects besides the page HTML.
+type RenderOptions struct {
+	Timeout time.Duration
+	// CaptureMedia records every image, video and audio response seen on the network.
+	CaptureMedia bool
+	// CaptureBodies also pulls the body of each captured response out of the browser.
+	CaptureBodies bool
+	// CaptureCanvas exports untainted <canvas> elements and resolves blob: media URLs to bytes.
+	CaptureCanvas bool
+	// Steps run after the body is ready and before the HTML is captured.
+	Steps []Step
+	// Screenshot captures a full-page PNG once the HTML has been read.
+	Screenshot bool
+	// PDF captures the page with Page.printToPDF; it only works in headless Chrome.
+	PDF bool
+	// Intercept blocks requests and adds headers and cookies while rendering; nil disables it.
+	Intercept *InterceptConfig
+	// Waits run after Steps; with none, the page counts as ready once its body is.
+	Waits []WaitStrategy
+	// Emulation renders the page as a given device and locale. Without it the browser
+	// keeps its defaults apart from a random User-Agent.
+	Emulation *EmulationProfile
+}
+
+// RenderResult is what RenderPageWithOptions returns.
+type RenderResult struct {
+	HTML  string
+	Media []CapturedMedia
+	// Fragments are iframe documents and open shadow roots, each with its own base URL.
+	Fragments  []Fragment
+	Screenshot []byte
+	PDF        []byte
+	Intercept  InterceptStats
+	// Timings lists how long each render phase took, in order.
+	Timings []PhaseTiming
+	// Warnings lists optional captures and waits that failed without failing the render.
+	Warnings []string
+}
+
 // RenderPage uses chromedp to render a page and return the HTML after JS execution.
 func RenderPage(url string, timeout time.Duration) (string, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
+	res, err := RenderPageWithOptions(url, RenderOptions{Timeout: timeout})
+	if err != nil {
+		return "", err
+	}
+	return res.HTML, nil
+}
+
+// RenderPageWithOptions renders a page like RenderPage and optionally harvests media from network traffic.
+func RenderPageWithOptions(url string, opts RenderOptions) (*RenderResult, error) {
+	ctx, cancel, err := newBrowserContext()
+	if err != nil {
+		return nil, err
+	}
 	defer cancel()
-	ctx, cancel = context.WithTimeout(ctx, timeout)
+	ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
-	var html string
-	err := chromedp.Run(ctx,
+	var capture *mediaCapture
+	if opts.CaptureMedia {
+		capture = newMediaCapture()
+		chromedp.ListenTarget(ctx, capture.onEvent)
+	}
+	res := &RenderResult{}
+	var tracker *inflightTracker
+	for _, w := range opts.Waits {
+		if w.Kind == WaitNetworkIdle && tracker == nil {
+			tracker = newInflightTracker()
+			chromedp.ListenTarget(ctx, tracker.onEvent)
+		}
+	}
+	emu := EmulationProfile{}
+	if opts.Emulation != nil {
+		emu = *opts.Emulation
+	}
+	actions := []chromedp.Action{emu.apply(url)}
+	var ic *interceptor
+	if opts.Intercept != nil {
+		ic = newInterceptor(opts.Intercept, url)
+		actions = append(actions, ic.install(url))
+	}
+	actions = append(actions, timed(&res.Timings, "navigate", chromedp.Tasks{
 		chromedp.Navigate(url),
 		chromedp.WaitReady("body", chromedp.ByQuery),
-		chromedp.OuterHTML("html", &html),
-	)
-	if err != nil {
-		return "", err
+	}))
+	if len(opts.Steps) > 0 {
+		actions = append(actions, timed(&res.Timings, "steps", runSteps(opts.Steps)))
+	}
+	if len(opts.Waits) > 0 {
+		actions = append(actions, runWaits(opts.Waits, tracker, &res.Timings, &res.Warnings))
+	}
+	actions = append(actions, timed(&res.Timings, "capture", chromedp.OuterHTML("html", &res.HTML)))
+	if opts.Screenshot {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if err := chromedp.FullScreenshot(&res.Screenshot, 100).Do(ctx); err != nil {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("screenshot: %v", err))
+			}
+			return nil
+		}))
+	}
+	if opts.PDF {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			buf, _, err := page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+			if err != nil {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("pdf: %v", err))
+				return nil
+			}
+			res.PDF = buf
+			return nil
+		}))
+	}
+	if capture != nil && opts.CaptureBodies {
+		actions = append(actions, chromedp.ActionFunc(capture.fetchBodies))
+	}
+	actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+		return collectFragments(ctx, res)
+	}))
+	if opts.CaptureCanvas {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return collectPageMedia(ctx, res)
+		}))
+	}
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		return nil, err
+	}
+	if ic != nil {
+		res.Intercept = ic.stats()
+	}
+	if capture != nil {
+		res.Media = append(capture.results(), res.Media...)
 	}
-	return html, nil
+	return res, nil
 }
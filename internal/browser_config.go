@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// BrowserConfig selects the Chrome used by RenderPage and the browser download fallback.
+type BrowserConfig struct {
+	// RemoteURL is a DevTools endpoint of an already running Chrome, either its websocket
+	// (ws://host:9222/devtools/browser/<id>) or its HTTP address (http://host:9222).
+	// When set, the local launch options below are ignored.
+	RemoteURL string
+
+	// Local launch options.
+	Headful      bool
+	UserDataDir  string
+	WindowWidth  int
+	WindowHeight int
+	// Flags are extra Chrome switches as "name" or "name=value", without leading dashes.
+	Flags []string
+}
+
+var (
+	browserConfigMu sync.RWMutex
+	browserConfig   BrowserConfig
+)
+
+// SetBrowserConfig changes the Chrome used for subsequent renders and browser downloads.
+func SetBrowserConfig(cfg BrowserConfig) {
+	browserConfigMu.Lock()
+	browserConfig = cfg
+	browserConfigMu.Unlock()
+}
+
+func currentBrowserConfig() BrowserConfig {
+	browserConfigMu.RLock()
+	defer browserConfigMu.RUnlock()
+	return browserConfig
+}
+
+// newBrowserContext returns a chromedp tab context for the configured Chrome.
+// Cancelling it closes the tab, and the browser too if it was launched locally.
+func newBrowserContext() (context.Context, context.CancelFunc, error) {
+	cfg := currentBrowserConfig()
+	var (
+		allocCtx    context.Context
+		allocCancel context.CancelFunc
+	)
+	if cfg.RemoteURL != "" {
+		if err := probeDevTools(cfg.RemoteURL); err != nil {
+			return nil, nil, err
+		}
+		allocCtx, allocCancel = chromedp.NewRemoteAllocator(context.Background(), cfg.RemoteURL)
+	} else {
+		allocCtx, allocCancel = chromedp.NewExecAllocator(context.Background(), cfg.execOptions()...)
+	}
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	return ctx, func() {
+		cancel()
+		allocCancel()
+	}, nil
+}
+
+func (cfg BrowserConfig) execOptions() []chromedp.ExecAllocatorOption {
+	opts := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+	if cfg.Headful {
+		opts = append(opts, chromedp.Flag("headless", false))
+	}
+	if cfg.UserDataDir != "" {
+		opts = append(opts, chromedp.UserDataDir(cfg.UserDataDir))
+	}
+	if cfg.WindowWidth > 0 && cfg.WindowHeight > 0 {
+		opts = append(opts, chromedp.WindowSize(cfg.WindowWidth, cfg.WindowHeight))
+	}
+	for _, f := range cfg.Flags {
+		f = strings.TrimLeft(strings.TrimSpace(f), "-")
+		if f == "" {
+			continue
+		}
+		if name, value, ok := strings.Cut(f, "="); ok {
+			opts = append(opts, chromedp.Flag(name, value))
+		} else {
+			opts = append(opts, chromedp.Flag(f, true))
+		}
+	}
+	return opts
+}
+
+// probeDevTools checks that a DevTools endpoint answers before chromedp tries to use it,
+// so an unreachable browser fails fast with a clear message instead of a render timeout.
+func probeDevTools(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid devtools endpoint %q: %w", endpoint, err)
+	}
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+	default:
+		return fmt.Errorf("invalid devtools endpoint %q: scheme must be ws, wss, http or https", endpoint)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return fmt.Errorf("invalid devtools endpoint %q: host needs a port (e.g. :9222)", endpoint)
+	}
+	u.Path, u.RawQuery = "/json/version", ""
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("devtools endpoint %s unreachable: %w", endpoint, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("devtools endpoint %s unreachable: %s from %s", endpoint, resp.Status, u)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// chunkThreshold is the size from which a download is split into parallel ranges.
+	chunkThreshold = 32 << 20
+	// chunkCount is how many ranges a chunked download is split into.
+	chunkCount = 4
+	// maxConnsPerHost caps concurrent downloads, including chunk requests, per host.
+	maxConnsPerHost = 4
+)
+
+var (
+	hostSlotsMu sync.Mutex
+	hostSlotMap = make(map[string]chan struct{})
+)
+
+// hostSlots returns the semaphore limiting concurrent connections to host.
+func hostSlots(host string) chan struct{} {
+	hostSlotsMu.Lock()
+	defer hostSlotsMu.Unlock()
+	s, ok := hostSlotMap[host]
+	if !ok {
+		s = make(chan struct{}, maxConnsPerHost)
+		hostSlotMap[host] = s
+	}
+	return s
+}
+
+// acquireHostSlot blocks until a connection to host is allowed.
+func acquireHostSlot(host string) { hostSlots(host) <- struct{}{} }
+
+// releaseHostSlot gives back slots taken for host.
+func releaseHostSlot(host string, n int) {
+	s := hostSlots(host)
+	for i := 0; i < n; i++ {
+		<-s
+	}
+}
+
+// tryAcquireHostSlots takes up to n free slots for host without waiting and returns how many it got.
+// Chunk fetchers use it on top of the slot their download already holds, so they can never
+// deadlock against other downloads waiting for the same host.
+func tryAcquireHostSlots(host string, n int) int {
+	s := hostSlots(host)
+	got := 0
+	for ; got < n; got++ {
+		select {
+		case s <- struct{}{}:
+		default:
+			return got
+		}
+	}
+	return got
+}
+
+// canChunk reports whether a fresh 200 response is worth re-fetching in parallel ranges.
+func canChunk(resp *http.Response, offset int64) bool {
+	return offset == 0 &&
+		resp.StatusCode == http.StatusOK &&
+		strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") &&
+		resp.ContentLength >= chunkThreshold
+}
+
+// downloadChunked fetches the file in chunkCount ranges, as many at once as the host's free
+// slots allow, into a scratch file. Only once every range has arrived in full (fetchRange
+// checks each one's length) is the scratch file moved into place as the completed part file, so a crash mid-way never
+// leaves a part file with holes in it.
+func (d *resumable) downloadChunked(client *http.Client, req *http.Request, size int64) error {
+	host := req.URL.Host
+	extra := tryAcquireHostSlots(host, chunkCount-1)
+	defer releaseHostSlot(host, extra)
+
+	tmp := d.part + ".chunks"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+
+	type span struct{ start, end int64 }
+	spans := make(chan span, chunkCount)
+	chunk := (size + chunkCount - 1) / chunkCount
+	for start := int64(0); start < size; start += chunk {
+		end := start + chunk - 1
+		if end >= size {
+			end = size - 1
+		}
+		spans <- span{start, end}
+	}
+	close(spans)
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < 1+extra; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sp := range spans {
+				if err := d.fetchRange(client, req, f, sp.start, sp.end); err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if cerr := f.Close(); firstErr == nil {
+		firstErr = cerr
+	}
+	if firstErr != nil {
+		return fmt.Errorf("chunked download: %w", firstErr)
+	}
+	d.offset = 0
+	d.state.Size = size
+	return os.Rename(tmp, d.part)
+}
+
+// fetchRange downloads bytes start..end (inclusive) into f at the same offset.
+func (d *resumable) fetchRange(client *http.Client, req *http.Request, f *os.File, start, end int64) error {
+	r := req.Clone(req.Context())
+	r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	// Make sure every range comes from the same version of the file.
+	if d.state.ETag != "" && !strings.HasPrefix(d.state.ETag, "W/") {
+		r.Header.Set("If-Range", d.state.ETag)
+	} else if d.state.LastModified != "" {
+		r.Header.Set("If-Range", d.state.LastModified)
+	}
+	// Each range is a request of its own, paced like any other against the host.
+	hostLimiter.Wait(r.URL.Host)
+	resp, err := client.Do(r)
+	if err != nil {
+		return classifyError(r.URL.String(), err)
+	}
+	defer resp.Body.Close()
+	hostLimiter.Observe(r.URL.Host, resp)
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range %d-%d: bad status: %s", start, end, resp.Status)
+	}
+	if got, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || got != start {
+		return fmt.Errorf("range %d-%d: unexpected Content-Range %q", start, end, resp.Header.Get("Content-Range"))
+	}
+	want := end - start + 1
+	n, err := io.Copy(io.NewOffsetWriter(f, start), io.LimitReader(throttle(r.URL.Hostname(), resp.Body), want))
+	if err != nil {
+		return classifyError(r.URL.String(), err)
+	}
+	if n != want {
+		return fmt.Errorf("range %d-%d: got %d of %d bytes", start, end, n, want)
+	}
+	return nil
+}
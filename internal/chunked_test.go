@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCanChunk(t *testing.T) {
+	resp := func(code int, ranges string, length int64) *http.Response {
+		h := http.Header{}
+		if ranges != "" {
+			h.Set("Accept-Ranges", ranges)
+		}
+		return &http.Response{StatusCode: code, Header: h, ContentLength: length}
+	}
+	tests := []struct {
+		name   string
+		resp   *http.Response
+		offset int64
+		want   bool
+	}{
+		{"large with ranges", resp(200, "bytes", chunkThreshold), 0, true},
+		{"case-insensitive", resp(200, "Bytes", chunkThreshold+1), 0, true},
+		{"too small", resp(200, "bytes", chunkThreshold-1), 0, false},
+		{"no ranges", resp(200, "none", chunkThreshold), 0, false},
+		{"unknown length", resp(200, "bytes", -1), 0, false},
+		{"resuming", resp(206, "bytes", chunkThreshold), 100, false},
+	}
+	for _, tt := range tests {
+		if got := canChunk(tt.resp, tt.offset); got != tt.want {
+			t.Errorf("%s: canChunk = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadChunked(t *testing.T) {
+	body := testBody(10000)
+	tests := []struct {
+		name    string
+		serve   func(w http.ResponseWriter, r *http.Request, ranges int)
+		wantErr bool
+	}{
+		{"all ranges", func(w http.ResponseWriter, r *http.Request, _ int) {
+			http.ServeContent(w, r, "f.bin", time.Time{}, bytes.NewReader(body))
+		}, false},
+		{"file changed mid-way", func(w http.ResponseWriter, r *http.Request, ranges int) {
+			if ranges == 3 {
+				// If-Range no longer matches: the whole new file comes back.
+				w.Header().Set("ETag", `"v2"`)
+				w.Write(bytes.ToUpper(body))
+				return
+			}
+			http.ServeContent(w, r, "f.bin", time.Time{}, bytes.NewReader(body))
+		}, true},
+		{"short range", func(w http.ResponseWriter, r *http.Request, ranges int) {
+			if ranges == 2 {
+				var start int
+				fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+9, len(body)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(body[start : start+10])
+				return
+			}
+			http.ServeContent(w, r, "f.bin", time.Time{}, bytes.NewReader(body))
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fastHosts(t)
+			var ranges atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				n := 0
+				if r.Header.Get("Range") != "" {
+					n = int(ranges.Add(1))
+				}
+				tt.serve(w, r, n)
+			}))
+			defer srv.Close()
+			req, _ := http.NewRequest("GET", srv.URL+"/f.bin", nil)
+			d, err := beginResumable(srv.Client(), req, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.resp.Body.Close()
+			err = d.downloadChunked(srv.Client(), req, int64(len(body)))
+			if _, serr := os.Stat(d.part + ".chunks"); !os.IsNotExist(serr) {
+				t.Error("scratch file left behind")
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				if _, serr := os.Stat(d.part); !os.IsNotExist(serr) {
+					t.Error("part file written from a failed chunked download")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(d.part)
+			if !bytes.Equal(got, body) {
+				t.Errorf("part file has %d bytes, want the %d-byte body", len(got), len(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// contentIndexName is the index file kept in the output root.
+const contentIndexName = ".content-index.json"
+
+// Dedup modes: what to do when a download's content is already on disk.
+const (
+	DedupOff      = "off"      // keep every file
+	DedupSkip     = "skip"     // drop the new copy and point at the existing file
+	DedupHardlink = "hardlink" // replace the new copy with a hard link to the existing file
+)
+
+// URLContent is what the index knows about one URL's last download.
+type URLContent struct {
+	SHA256    string `json:"sha256"`
+	File      string `json:"file"` // relative to the index directory
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// ContentIndex maps content hashes to the file holding that content, across runs.
+type ContentIndex struct {
+	Files map[string]string     `json:"files"` // sha256 -> file relative to the index directory
+	URLs  map[string]URLContent `json:"urls"`
+
+	dir   string
+	mode  string
+	dirty bool // changed since the index was last written; see Flush
+	mu    sync.Mutex
+}
+
+var (
+	contentIndexMu sync.RWMutex
+	contentIndex   *ContentIndex
+)
+
+// OpenContentIndex loads (or starts) the index in dir.
+func OpenContentIndex(dir, mode string) (*ContentIndex, error) {
+	switch mode {
+	case DedupOff, DedupSkip, DedupHardlink:
+	default:
+		return nil, fmt.Errorf("unknown dedup mode %q", mode)
+	}
+	ix := &ContentIndex{Files: map[string]string{}, URLs: map[string]URLContent{}, dir: dir, mode: mode}
+	data, err := os.ReadFile(filepath.Join(dir, contentIndexName))
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ix); err != nil {
+		return nil, fmt.Errorf("parse content index: %w", err)
+	}
+	if ix.Files == nil {
+		ix.Files = map[string]string{}
+	}
+	if ix.URLs == nil {
+		ix.URLs = map[string]URLContent{}
+	}
+	return ix, nil
+}
+
+// SetContentIndex makes every download path hash its files against ix; nil disables it.
+func SetContentIndex(ix *ContentIndex) {
+	contentIndexMu.Lock()
+	contentIndex = ix
+	contentIndexMu.Unlock()
+}
+
+func currentContentIndex() *ContentIndex {
+	contentIndexMu.RLock()
+	defer contentIndexMu.RUnlock()
+	return contentIndex
+}
+
+// Lookup returns what the index recorded for rawurl.
+func (ix *ContentIndex) Lookup(rawurl string) (URLContent, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	c, ok := ix.URLs[rawurl]
+	return c, ok
+}
+
+// AbsPath resolves a path stored in the index.
+func (ix *ContentIndex) AbsPath(rel string) string {
+	return filepath.Join(ix.dir, rel)
+}
+
+// place moves the finished file src to dest, unless a file with the same content already
+// exists, in which case src is dropped or hard-linked per the dedup mode. It returns the
+// path now holding the content for rawurl.
+func (ix *ContentIndex) place(rawurl, src, dest, sum string) (string, bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	final, dup := dest, false
+	if existing, ok := ix.Files[sum]; ok && ix.mode != DedupOff {
+		existingAbs := filepath.Join(ix.dir, existing)
+		if _, err := os.Stat(existingAbs); err == nil {
+			dup = true
+			switch {
+			case ix.mode == DedupHardlink && os.Link(existingAbs, dest) == nil:
+				if err := os.Remove(src); err != nil {
+					return "", false, err
+				}
+			case ix.mode == DedupHardlink:
+				// No hard links here (another device, or a filesystem without them): keep the
+				// downloaded bytes as a copy instead of losing the file.
+				if err := os.Rename(src, dest); err != nil {
+					return "", false, err
+				}
+			default:
+				if err := os.Remove(src); err != nil {
+					return "", false, err
+				}
+				final = existingAbs
+			}
+		}
+	}
+	if !dup {
+		if err := os.Rename(src, dest); err != nil {
+			return "", false, err
+		}
+	}
+	rel, err := filepath.Rel(ix.dir, final)
+	if err != nil {
+		rel = final
+	}
+	if !dup {
+		ix.Files[sum] = rel
+	}
+	ix.URLs[indexKey(rawurl, sum)] = URLContent{SHA256: sum, File: rel, Duplicate: dup}
+	ix.dirty = true
+	return final, dup, nil
+}
+
+// record notes that rawurl's content sum is already in place at path.
+func (ix *ContentIndex) record(rawurl, path, sum string) error {
+	rel, err := filepath.Rel(ix.dir, path)
+	if err != nil {
+		rel = path
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.Files[sum]; !ok {
+		ix.Files[sum] = rel
+	}
+	ix.URLs[indexKey(rawurl, sum)] = URLContent{SHA256: sum, File: rel}
+	ix.dirty = true
+	return nil
+}
+
+// relocate points index entries for a file that was moved from oldPath to newPath.
+func (ix *ContentIndex) relocate(oldPath, newPath string) error {
+	oldRel, err := filepath.Rel(ix.dir, oldPath)
+	if err != nil {
+		return nil
+	}
+	newRel, err := filepath.Rel(ix.dir, newPath)
+	if err != nil {
+		return nil
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for sum, f := range ix.Files {
+		if f == oldRel {
+			ix.Files[sum] = newRel
+		}
+	}
+	for u, c := range ix.URLs {
+		if c.File == oldRel {
+			c.File = newRel
+			ix.URLs[u] = c
+		}
+	}
+	ix.dirty = true
+	return nil
+}
+
+// Flush writes the index if anything changed since the last write. Files are recorded in
+// memory as they are placed, so a batch costs one write rather than one per file.
+func (ix *ContentIndex) Flush() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.dirty {
+		return nil
+	}
+	if err := ix.saveLocked(); err != nil {
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
+
+// flushContentIndex writes the shared index, if any, reporting failures in the log.
+func flushContentIndex() {
+	if ix := currentContentIndex(); ix != nil {
+		if err := ix.Flush(); err != nil {
+			fmt.Printf("Failed to save content index: %v\n", err)
+		}
+	}
+}
+
+func (ix *ContentIndex) saveLocked() error {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(ix.dir, contentIndexName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(ix.dir, contentIndexName))
+}
+
+// placeFile moves a finished file into place through the content index, if one is set.
+// sum may be empty, in which case the file is hashed here.
+func placeFile(rawurl, src, dest, sum string) (string, error) {
+	ix := currentContentIndex()
+	if ix == nil {
+		return dest, os.Rename(src, dest)
+	}
+	if sum == "" {
+		var err error
+		if sum, err = hashFile(src); err != nil {
+			return "", err
+		}
+	}
+	final, _, err := ix.place(rawurl, src, dest, sum)
+	return final, err
+}
+
+// saveBytes writes data fetched from v.URL under outDir, named and deduplicated like any
+// other download.
+func saveBytes(outDir string, v NameVars, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(outDir, ".save-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	v.SHA256 = hex.EncodeToString(sum[:])
+	final, err := StoreFile(tmp.Name(), outDir, v)
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return final, err
+}
+
+// indexKey is the key a URL is recorded under; inline data: payloads are replaced by their hash.
+func indexKey(rawurl, sum string) string {
+	if strings.HasPrefix(rawurl, "data:") {
+		return "data:sha256," + sum
+	}
+	return rawurl
+}
+
+// hashFile returns the hex SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentIndexPlace(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantDup   bool
+		wantFinal string // "existing" or "dest"
+		wantDest  bool   // a file exists at the second download's name
+	}{
+		{DedupOff, false, "dest", true},
+		{DedupSkip, true, "existing", false},
+		{DedupHardlink, true, "dest", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			ix, err := OpenContentIndex(dir, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write := func(name string) string {
+				p := filepath.Join(dir, name)
+				os.WriteFile(p, []byte("same content"), 0644)
+				return p
+			}
+			sum, _ := hashFile(write("first.part"))
+			first := filepath.Join(dir, "first.jpg")
+			if got, dup, err := ix.place("http://x/1.jpg", filepath.Join(dir, "first.part"), first, sum); err != nil || dup || got != first {
+				t.Fatalf("first place = %q, %v, %v", got, dup, err)
+			}
+
+			src, second := write("second.part"), filepath.Join(dir, "second.jpg")
+			got, dup, err := ix.place("http://x/2.jpg", src, second, sum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := second
+			if tt.wantFinal == "existing" {
+				want = first
+			}
+			if dup != tt.wantDup || got != want {
+				t.Errorf("second place = %q, dup %v; want %q, dup %v", got, dup, want, tt.wantDup)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Error("source part file left behind")
+			}
+			if _, err := os.Stat(second); (err == nil) != tt.wantDest {
+				t.Errorf("second.jpg exists = %v, want %v", err == nil, tt.wantDest)
+			}
+			if tt.mode == DedupHardlink {
+				a, _ := os.Stat(first)
+				b, _ := os.Stat(second)
+				if a == nil || b == nil || !os.SameFile(a, b) {
+					t.Error("second.jpg is not a hard link to first.jpg")
+				}
+			}
+
+			// The index survives a restart once flushed.
+			if err := ix.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := OpenContentIndex(dir, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := reopened.Lookup("http://x/2.jpg")
+			if !ok || c.SHA256 != sum || c.Duplicate != tt.wantDup || reopened.AbsPath(c.File) != want {
+				t.Errorf("reopened lookup = %+v, %v", c, ok)
+			}
+		})
+	}
+}
+
+func TestContentIndexUnknownMode(t *testing.T) {
+	if _, err := OpenContentIndex(t.TempDir(), "sometimes"); err == nil {
+		t.Error("unknown dedup mode: got no error")
+	}
+}
+
+func TestContentIndexRelocate(t *testing.T) {
+	dir := t.TempDir()
+	ix, _ := OpenContentIndex(dir, DedupSkip)
+	old := filepath.Join(dir, "a.jpg")
+	os.WriteFile(old, []byte("a"), 0644)
+	if err := ix.record("http://x/a.jpg", old, "sum-a"); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, nearDupDir, "a.jpg")
+	if err := ix.relocate(old, moved); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := ix.Lookup("http://x/a.jpg")
+	if ix.AbsPath(c.File) != moved || ix.AbsPath(ix.Files["sum-a"]) != moved {
+		t.Errorf("after relocate: url -> %q, hash -> %q; want %q", c.File, ix.Files["sum-a"], moved)
+	}
+}
+
+func TestContentIndexFlush(t *testing.T) {
+	dir := t.TempDir()
+	ix, _ := OpenContentIndex(dir, DedupSkip)
+	for _, name := range []string{"a", "b"} {
+		p := filepath.Join(dir, name+".jpg")
+		os.WriteFile(p, []byte(name), 0644)
+		if err := ix.record("http://x/"+name+".jpg", p, "sum-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := filepath.Join(dir, contentIndexName)
+	if _, err := os.Stat(index); !os.IsNotExist(err) {
+		t.Fatal("index written before Flush")
+	}
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, _ := OpenContentIndex(dir, DedupSkip)
+	if len(reopened.URLs) != 2 || len(reopened.Files) != 2 {
+		t.Errorf("flushed index has %d URLs, %d files; want 2, 2", len(reopened.URLs), len(reopened.Files))
+	}
+	// Nothing changed since: a second Flush leaves the file alone.
+	os.Remove(index)
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(index); !os.IsNotExist(err) {
+		t.Error("clean index written again")
+	}
+}
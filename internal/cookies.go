@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is a cookie jar with public suffix rules that can keep its cookies in a file, so
+// anti-hotlink cookies picked up by one run are still there for the next.
+type CookieJar struct {
+	jar  *cookiejar.Jar
+	path string // empty keeps cookies in memory only
+
+	mu    sync.Mutex
+	saved map[string]savedCookie
+}
+
+// savedCookie is a cookie as persisted, with the URL that set it.
+type savedCookie struct {
+	URL    string      `json:"url"`
+	Cookie http.Cookie `json:"cookie"`
+}
+
+var (
+	cookieJarMu sync.RWMutex
+	cookieJar   *CookieJar
+)
+
+// NewCookieJar returns an empty jar, or with path set, one loaded from and saved to that file.
+func NewCookieJar(path string) (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	j := &CookieJar{jar: jar, path: path, saved: map[string]savedCookie{}}
+	if path == "" {
+		return j, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []savedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parse cookie file: %w", err)
+	}
+	now := time.Now()
+	for _, s := range saved {
+		u, err := url.Parse(s.URL)
+		if err != nil || (!s.Cookie.Expires.IsZero() && s.Cookie.Expires.Before(now)) {
+			continue
+		}
+		c := s.Cookie
+		jar.SetCookies(u, []*http.Cookie{&c})
+		j.saved[cookieKey(u, &c)] = s
+	}
+	return j, nil
+}
+
+// SetCookieJar makes every batch share j; nil gives each batch a fresh in-memory jar.
+func SetCookieJar(j *CookieJar) {
+	cookieJarMu.Lock()
+	cookieJar = j
+	cookieJarMu.Unlock()
+}
+
+func currentCookieJar() *CookieJar {
+	cookieJarMu.RLock()
+	defer cookieJarMu.RUnlock()
+	return cookieJar
+}
+
+// batchJar returns the shared jar, or a new one for a batch when none is set.
+func batchJar() *CookieJar {
+	if j := currentCookieJar(); j != nil {
+		return j
+	}
+	j, _ := NewCookieJar("") // only fails on a bad public suffix list, which is fixed
+	return j
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie { return j.jar.Cookies(u) }
+
+// SetCookies implements http.CookieJar, writing the jar to its file when it has one.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	if j.path == "" {
+		return
+	}
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(j.saved, key)
+			continue
+		}
+		s := savedCookie{URL: (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(), Cookie: *c}
+		if c.MaxAge > 0 {
+			// Max-Age is relative to now; keep the moment it runs out instead.
+			s.Cookie.Expires, s.Cookie.MaxAge = now.Add(time.Duration(c.MaxAge)*time.Second), 0
+		}
+		s.Cookie.Raw, s.Cookie.Unparsed = "", nil
+		j.saved[key] = s
+	}
+	if err := j.saveLocked(); err != nil {
+		fmt.Printf("Failed to save cookies: %v\n", err)
+	}
+}
+
+// cookieKey identifies a cookie the way the jar does: by domain, path and name.
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := c.Domain
+	if domain == "" {
+		domain = u.Hostname()
+	}
+	return domain + ";" + c.Path + ";" + c.Name
+}
+
+func (j *CookieJar) saveLocked() error {
+	saved := make([]savedCookie, 0, len(j.saved))
+	for _, s := range j.saved {
+		saved = append(saved, s)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// visitHostingPage loads pageURL once so the jar picks up the cookies a site hands out
+// before it serves its images (anti-hotlink protection).
+func visitHostingPage(jar http.CookieJar, pageURL string) {
+	if pageURL == "" {
+		return
+	}
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", RandomUserAgent())
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Connection", "keep-alive")
+	client := &http.Client{Timeout: 30 * time.Second, Transport: HTTPTransport(), Jar: jar}
+	hostLimiter.Wait(req.URL.Host)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Hosting page visit failed for %s: %v\n", pageURL, err)
+		return
+	}
+	hostLimiter.Observe(req.URL.Host, resp)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20)) // drain so the connection can be reused
+	resp.Body.Close()
+}
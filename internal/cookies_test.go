@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCookieJarPersistence(t *testing.T) {
+	type set struct {
+		url    string
+		cookie http.Cookie
+	}
+	tests := []struct {
+		name      string
+		sets      []set
+		wantSaved int
+		readURL   string
+		want      string // cookies sent to readURL after a reload
+	}{
+		{
+			name:      "max-age survives as expires",
+			sets:      []set{{"http://a.example.com/", http.Cookie{Name: "s", Value: "1", MaxAge: 3600}}},
+			wantSaved: 1, readURL: "http://a.example.com/", want: "s=1",
+		},
+		{
+			name: "negative max-age deletes",
+			sets: []set{
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "1", MaxAge: 3600}},
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "", MaxAge: -1}},
+			},
+			wantSaved: 0, readURL: "http://a.example.com/", want: "",
+		},
+		{
+			name: "same key replaces",
+			sets: []set{
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "1"}},
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "2"}},
+			},
+			wantSaved: 1, readURL: "http://a.example.com/", want: "s=2",
+		},
+		{
+			name: "paths kept apart",
+			sets: []set{
+				{"http://a.example.com/x/", http.Cookie{Name: "s", Value: "1", Path: "/x"}},
+				{"http://a.example.com/y/", http.Cookie{Name: "s", Value: "2", Path: "/y"}},
+			},
+			wantSaved: 2, readURL: "http://a.example.com/y/img.jpg", want: "s=2",
+		},
+		{
+			name: "hosts kept apart",
+			sets: []set{
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "1"}},
+				{"http://b.example.com/", http.Cookie{Name: "s", Value: "2"}},
+			},
+			wantSaved: 2, readURL: "http://b.example.com/", want: "s=2",
+		},
+		{
+			name: "domain cookie",
+			sets: []set{
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "1", Domain: "example.com"}},
+				{"http://a.example.com/", http.Cookie{Name: "s", Value: "2"}},
+			},
+			wantSaved: 2, readURL: "http://cdn.example.com/", want: "s=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cookies.json")
+			j, err := NewCookieJar(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.sets {
+				u, _ := url.Parse(s.url)
+				c := s.cookie
+				j.SetCookies(u, []*http.Cookie{&c})
+			}
+			var saved []savedCookie
+			data, _ := os.ReadFile(path)
+			if err := json.Unmarshal(data, &saved); err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != tt.wantSaved {
+				t.Fatalf("%d cookies saved, want %d: %+v", len(saved), tt.wantSaved, saved)
+			}
+			for _, s := range saved {
+				if s.Cookie.MaxAge != 0 {
+					t.Errorf("cookie %s saved with relative Max-Age %d", s.Cookie.Name, s.Cookie.MaxAge)
+				}
+			}
+
+			reloaded, err := NewCookieJar(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(tt.readURL)
+			got := ""
+			for _, c := range reloaded.Cookies(u) {
+				got += c.String()
+			}
+			if got != tt.want {
+				t.Errorf("after reload, %s gets %q, want %q", tt.readURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieJarMaxAgeExpires(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	j, _ := NewCookieJar(path)
+	u, _ := url.Parse("http://a.example.com/")
+	j.SetCookies(u, []*http.Cookie{{Name: "s", Value: "1", MaxAge: 3600}})
+	exp := j.saved[cookieKey(u, &http.Cookie{Name: "s"})].Cookie.Expires
+	if d := time.Until(exp); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Max-Age 3600 saved as expiry in %v", d)
+	}
+}
+
+func TestCookieJarDropsExpiredOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	saved := []savedCookie{
+		{URL: "http://a.example.com/", Cookie: http.Cookie{Name: "old", Value: "1", Expires: time.Now().Add(-time.Hour)}},
+		{URL: "http://a.example.com/", Cookie: http.Cookie{Name: "new", Value: "2", Expires: time.Now().Add(time.Hour)}},
+		{URL: "http://a.example.com/", Cookie: http.Cookie{Name: "session", Value: "3"}},
+	}
+	data, _ := json.Marshal(saved)
+	os.WriteFile(path, data, 0600)
+	j, err := NewCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.saved) != 2 {
+		t.Errorf("%d cookies kept, want 2", len(j.saved))
+	}
+	u, _ := url.Parse("http://a.example.com/")
+	for _, c := range j.Cookies(u) {
+		if c.Name == "old" {
+			t.Error("expired cookie loaded into the jar")
+		}
+	}
+}
@@ -2,46 +2,63 @@ package internal
 
 import (
 	"context"
-	crand "crypto/rand"
-	"crypto/tls"
 	"encoding/base64"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
-	"io"
 	mrand "math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-// DownloadImagesAdvancedBatch downloads images concurrently using AdvancedDownloadFile, with per-domain rate limiting, cookie reuse, and stats.
-func DownloadImagesAdvancedBatch(imgURLs []string, pageURL, outDir string) {
-	type result struct {
-		url     string
-		method  string // basic/cookies/browser
-		errType string
-		err     error
-	}
-	var (
-		wg             sync.WaitGroup
-		mu             sync.Mutex
-		results        []result
-		domainLastTime = make(map[string]time.Time)
-		domainDelay    = 1200 * time.Millisecond // per-domain delay
-		domainMu       = make(map[string]*sync.Mutex)
-	)
+// contentTypeToExt maps common content types to file extensions.
+var contentTypeToExt = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/bmp":        ".bmp",
+	"image/tiff":       ".tiff",
+	"image/avif":       ".avif",
+	"image/svg+xml":    ".svg",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"video/ogg":        ".ogv",
+	"video/quicktime":  ".mov",
+	"audio/mpeg":       ".mp3",
+	"audio/mp4":        ".m4a",
+	"audio/ogg":        ".ogg",
+	"audio/wav":        ".wav",
+	"audio/webm":       ".weba",
+	"image/heic":       ".heic",
+	"image/heif":       ".heif",
+	"video/x-matroska": ".mkv",
+}
+
+// DownloadImagesAdvancedBatch downloads images concurrently using AdvancedDownloadFile, with shared per-host rate limiting, cookie reuse, and stats.
+// It returns what happened to each URL, in the order given.
+func DownloadImagesAdvancedBatch(imgURLs []string, pageURL, outDir string) *BatchResult {
+	start := time.Now()
+	connsBefore, _ := ConnectionStats()
+	batch := &BatchResult{Results: make([]DownloadResult, len(imgURLs))}
+	var wg sync.WaitGroup
+	// Visit the hosting page once, before the workers start, for its anti-hotlink cookies
+	jar := batchJar()
+	visitHostingPage(jar, pageURL)
 	getDomain := func(rawurl string) string {
 		u, _ := url.Parse(rawurl)
 		return u.Host
 	}
-	// Group images by domain for batch cookie reuse
+	// Group images by domain for per-host slots
 	type imgTask struct {
 		url    string
 		domain string
@@ -59,41 +76,12 @@ func DownloadImagesAdvancedBatch(imgURLs []string, pageURL, outDir string) {
 		go func() {
 			defer wg.Done()
 			for task := range jobs {
+				// Requests are paced per host by the shared limiter, across all jobs
 				d := task.domain
-				// Per-domain mutex for rate limiting
-				mu.Lock()
-				if domainMu[d] == nil {
-					domainMu[d] = &sync.Mutex{}
-				}
-				domMu := domainMu[d]
-				mu.Unlock()
-				domMu.Lock()
-				last := domainLastTime[d]
-				wait := domainDelay - time.Since(last)
-				if wait > 0 {
-					time.Sleep(wait)
-				}
-				domainLastTime[d] = time.Now()
-				domMu.Unlock()
-				// Try download, track escalation method
-				method := "basic"
-				err := AdvancedDownloadFileWithStats(task.url, pageURL, outDir, task.idx, &method)
-				mu.Lock()
-				r := result{url: task.url, method: method}
-				if err != nil {
-					r.err = err
-					if strings.Contains(err.Error(), "403") {
-						r.errType = "403 Forbidden"
-					} else if strings.Contains(err.Error(), "timeout") {
-						r.errType = "Timeout"
-					} else if strings.Contains(err.Error(), "SSL") || strings.Contains(err.Error(), "tls") {
-						r.errType = "SSL/TLS"
-					} else {
-						r.errType = "Other"
-					}
-				}
-				results = append(results, r)
-				mu.Unlock()
+				// Try download, track escalation method; chunked downloads may take more of the host's slots
+				acquireHostSlot(d)
+				batch.Results[task.idx-1] = downloadResult(jar, task.url, pageURL, outDir, task.idx)
+				releaseHostSlot(d, 1)
 			}
 		}()
 	}
@@ -102,65 +90,96 @@ func DownloadImagesAdvancedBatch(imgURLs []string, pageURL, outDir string) {
 	}
 	close(jobs)
 	wg.Wait()
+	flushDownloadState()
+	batch.tally(time.Since(start))
+	conns, _ := ConnectionStats()
+	batch.Connections = ConnStats{New: conns.New - connsBefore.New, Reused: conns.Reused - connsBefore.Reused}
 	// Stats
-	stats := map[string]int{"basic": 0, "cookies": 0, "browser": 0}
+	stats := map[string]int{}
 	errStats := map[string]int{}
-	for _, r := range results {
-		stats[r.method]++
-		if r.errType != "" {
-			errStats[r.errType]++
+	for _, r := range batch.Results {
+		stats[r.Method]++
+		if r.ErrorType != "" {
+			errStats[r.ErrorType]++
 		}
 	}
-	fmt.Printf("\nDownload summary: Success: %d, Errors: %d\n", stats["basic"]+stats["cookies"]+stats["browser"]-len(errStats), len(errStats))
-	fmt.Printf("By method: basic=%d, cookies=%d, browser=%d\n", stats["basic"], stats["cookies"], stats["browser"])
+	fmt.Printf("\nDownload summary: Success: %d, Rejected: %d, Errors: %d\n", batch.Succeeded, batch.Rejected, batch.Failed)
+	fmt.Printf("By method: basic=%d, cookies=%d, insecure-tls=%d, browser=%d\n",
+		stats[MethodBasic], stats[MethodCookies], stats[MethodInsecureTLS], stats[MethodBrowser])
+	fmt.Printf("Connections: new=%d, reused=%d\n", batch.Connections.New, batch.Connections.Reused)
 	fmt.Println("Error breakdown:")
 	for k, v := range errStats {
 		fmt.Printf("  %s: %d\n", k, v)
 	}
+	return batch
 }
 
-// AdvancedDownloadFileWithStats wraps AdvancedDownloadFile and sets method to escalation used.
-func AdvancedDownloadFileWithStats(imgURL, pageURL, outDir string, idx int, method *string) error {
-	err := AdvancedDownloadFile(imgURL, pageURL, outDir, idx)
+// downloadResult runs advancedDownload for one URL and records the outcome.
+func downloadResult(jar http.CookieJar, imgURL, pageURL, outDir string, idx int) DownloadResult {
+	start := time.Now()
+	r := DownloadResult{URL: imgURL, Status: DownloadOK}
+	err := advancedDownload(jar, imgURL, pageURL, outDir, idx, &r)
+	r.DurationMS = time.Since(start).Milliseconds()
 	if err == nil {
-		*method = "basic"
-		return nil
+		return r
 	}
-	if strings.Contains(err.Error(), "cookies") {
-		*method = "cookies"
-	} else if strings.Contains(err.Error(), "chromedp") || strings.Contains(err.Error(), "browser") {
-		*method = "browser"
+	r.Status, r.ErrorType, r.Error = DownloadFailed, errorCategory(err), err.Error()
+	if rej, ok := rejectionFor(imgURL, err); ok {
+		r.Status, r.Error = DownloadRejected, rej.Reason
 	}
+	return r
+}
+
+// AdvancedDownloadFileWithStats runs AdvancedDownloadFile and sets method to the escalation tier it ended up using.
+func AdvancedDownloadFileWithStats(imgURL, pageURL, outDir string, idx int, method *string) error {
+	defer flushDownloadState()
+	var r DownloadResult
+	jar := batchJar()
+	visitHostingPage(jar, pageURL)
+	err := advancedDownload(jar, imgURL, pageURL, outDir, idx, &r)
+	*method = r.Method
 	return err
 }
 
 // AdvancedDownloadFile downloads a file with realistic browser headers, SSL/TLS config, anti-hotlink bypass, and chromedp fallback.
 // If 403 or HTTPS error, it will escalate to browser simulation and use cookies from the hosting page.
 func AdvancedDownloadFile(imgURL, pageURL, outDir string, idx int) error {
-	// 1. Visit the hosting page to get cookies (anti-hotlink bypass)
-	jar, _ := http.DefaultClient.Jar, http.DefaultClient.Jar // use default jar if available
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig:   nil, // use system certs
-			DisableKeepAlives: false,
-			ForceAttemptHTTP2: true,
-		},
-		Jar: jar,
+	defer flushDownloadState()
+	jar := batchJar()
+	visitHostingPage(jar, pageURL)
+	return advancedDownload(jar, imgURL, pageURL, outDir, idx, &DownloadResult{})
+}
+
+// flushDownloadState writes the HTTP cache and content index once a download or batch is done.
+func flushDownloadState() {
+	flushHTTPCache()
+	flushContentIndex()
+}
+
+// relFile names path relative to outDir. A file of another job, such as the copy a dedup skip
+// points at, comes out as ../<job>/....
+func relFile(outDir, path string) string {
+	if rel, err := filepath.Rel(outDir, path); err == nil {
+		return rel
 	}
-	// Visit the page to get cookies
-	pageReq, _ := http.NewRequest("GET", pageURL, nil)
-	pageReq.Header.Set("User-Agent", RandomUserAgent())
-	pageReq.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	pageReq.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	pageReq.Header.Set("Connection", "keep-alive")
-	_, _ = client.Do(pageReq) // ignore error, just for cookies
+	return path
+}
 
+// advancedDownload is AdvancedDownloadFile without the hosting page visit, using the cookies
+// already in jar. It fills in r: the escalation tier (Method*) used, attempts, last HTTP
+// status, and the file saved with its size.
+func advancedDownload(jar http.CookieJar, imgURL, pageURL, outDir string, idx int, r *DownloadResult) error {
+	r.Method = MethodBasic
+	// 1. Cookies from the hosting page (anti-hotlink bypass) come with the jar
+	client := newDownloadClient(jar, HTTPTransport())
 	// 2. Prepare realistic browser headers for image request
 	req, err := http.NewRequest("GET", imgURL, nil)
 	if err != nil {
 		return err
 	}
+	if len(jar.Cookies(req.URL)) > 0 {
+		r.Method = MethodCookies
+	}
 	req.Header.Set("User-Agent", RandomUserAgent())
 	req.Header.Set("Accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
@@ -173,114 +192,213 @@ func AdvancedDownloadFile(imgURL, pageURL, outDir string, idx int) error {
 	// 3. Try download with retries and error handling
 	const maxRetries = 3
 	var lastErr error
+	noChunks := false
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		resp, err := client.Do(req)
+		r.Attempts = attempt + 1
+		dl, err := beginResumable(client, req, outDir)
+		if errors.Is(err, errAlreadyDownloaded) {
+			// Finished by an earlier attempt, or unchanged (304) since an earlier run
+			r.Status = DownloadSkipped
+			if errors.Is(err, errNotModified) {
+				r.Status, r.HTTPCode = DownloadUnchanged, http.StatusNotModified
+			}
+			path := dl.state.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(outDir, path)
+			}
+			r.File = relFile(outDir, path)
+			if info, err := os.Stat(path); err == nil {
+				r.Bytes = info.Size()
+			}
+			return nil
+		}
 		if err != nil {
 			// HTTPS error: try with InsecureSkipVerify (not recommended for prod)
-			if strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") && attempt == 0 {
-				client.Transport = &http.Transport{
-					TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-					DisableKeepAlives: false,
-					ForceAttemptHTTP2: true,
-				}
+			var tlsErr *TLSError
+			if errors.As(err, &tlsErr) && attempt == 0 {
+				client.Transport = idleTimeoutTransport{base: insecureTransport(), idle: readIdleTimeout}
+				r.Method = MethodInsecureTLS
+				lastErr = err
 				continue
 			}
 			lastErr = err
 			time.Sleep(time.Duration(500+100*attempt) * time.Millisecond)
 			continue
 		}
+		resp := dl.resp
 		defer resp.Body.Close()
+		r.HTTPCode = resp.StatusCode
 		if resp.StatusCode == 403 && attempt == maxRetries-1 {
 			// Escalate: use chromedp to fetch image with cookies
-			return downloadWithChromedp(imgURL, outDir, idx)
+			r.Method = MethodBrowser
+			path, size, err := downloadWithChromedp(imgURL, pageURL, outDir, idx)
+			if err == nil {
+				r.File, r.Bytes = relFile(outDir, path), size
+			}
+			return err
 		}
 		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-			lastErr = fmt.Errorf("bad status: %s", resp.Status)
+			lastErr = &HTTPStatusError{URL: imgURL, StatusCode: resp.StatusCode, Status: resp.Status}
 			time.Sleep(time.Duration(500+100*attempt) * time.Millisecond)
 			continue
 		}
-		// Save file
-		ext := filepath.Ext(imgURL)
-		if len(ext) == 0 || len(ext) > 10 {
-			ext = ".jpg"
-		}
-		rnd := make([]byte, 4)
-		_, _ = crand.Read(rnd)
-		rndStr := hex.EncodeToString(rnd)
-		fname := fmt.Sprintf("file_%s_%03d%s", rndStr, idx, ext)
-		fpath := filepath.Join(outDir, fname)
-		f, err := os.Create(fpath)
+		// Look at the first bytes before writing anything: wrong type or filtered out is final
+		ins, err := dl.inspect(throttle(req.URL.Hostname(), resp.Body), resp.Header.Get("Content-Type"), dl.state.Size)
 		if err != nil {
+			dl.discard()
+			return err
+		}
+		reportMismatch(imgURL, ins.Type)
+		if !noChunks && canChunk(resp, dl.offset) {
+			// Large file on a server that takes ranges: fetch it in parallel pieces
+			resp.Body.Close()
+			if err := dl.downloadChunked(client, req, resp.ContentLength); err != nil {
+				noChunks = true // fall back to a single stream
+				lastErr = err
+				continue
+			}
+		} else if _, err := dl.write(ins.body); err != nil {
+			// Save to the part file; an interrupted copy is resumed on the next attempt
+			if isFinal(err) {
+				dl.discard()
+				return err
+			}
 			lastErr = err
 			continue
 		}
-		defer f.Close()
-		_, err = io.Copy(f, resp.Body)
+		if err := dl.checkSize(); err != nil {
+			dl.discard()
+			return err
+		}
+		size := dl.size()
+		path, err := dl.finish(outDir, NameVars{PageURL: pageURL, Idx: idx, Ext: ins.Type.Ext})
 		if err != nil {
 			lastErr = err
 			continue
 		}
-		info, err := f.Stat()
-		if err == nil && info.Size() < 10*1024 {
-			f.Close()
-			os.Remove(fpath)
-			lastErr = fmt.Errorf("file %s deleted (size < 10KB)", fname)
-			continue
-		}
+		r.File, r.Bytes = relFile(outDir, path), size
 		return nil // success
 	}
-	return fmt.Errorf("advanced download failed for %s: %v", imgURL, lastErr)
+	return fmt.Errorf("advanced download failed for %s: %w", imgURL, lastErr)
 }
 
-// downloadWithChromedp fetches an image using a headless browser and saves it.
-func downloadWithChromedp(imgURL, outDir string, idx int) error {
-	ctx, cancel := chromedp.NewContext(context.Background())
+// browserFetchJS fetches a URL from inside the page, so the request carries the browser's
+// cookies, headers and TLS fingerprint, and hands the bytes back as base64.
+const browserFetchJS = `(async (u) => {
+	const r = await fetch(u, {credentials: 'include', cache: 'force-cache'});
+	if (!r.ok) throw new Error('bad status: ' + r.status);
+	const buf = new Uint8Array(await r.arrayBuffer());
+	let bin = '';
+	for (let i = 0; i < buf.length; i += 0x8000) {
+		bin += String.fromCharCode.apply(null, buf.subarray(i, i + 0x8000));
+	}
+	return {type: r.headers.get('content-type') || '', data: btoa(bin)};
+})(%s)`
+
+// browserFetchResult is the value returned by browserFetchJS.
+type browserFetchResult struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// fetchInPage runs browserFetchJS for imgURL in the current tab.
+func fetchInPage(imgURL string, out *browserFetchResult) chromedp.Action {
+	arg, _ := json.Marshal(imgURL)
+	return chromedp.Evaluate(fmt.Sprintf(browserFetchJS, arg), out, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	})
+}
+
+// browserFetchTimeout bounds the browser fallback: visiting the hosting page and fetching the file.
+const browserFetchTimeout = 90 * time.Second
+
+// downloadWithChromedp fetches an image through a headless browser and saves it.
+// It visits the hosting page first and fetches from inside it; if the page's origin
+// is refused (CORS), it retries from a tab navigated to the image itself.
+// It returns the saved file and its size.
+func downloadWithChromedp(imgURL, pageURL, outDir string, idx int) (string, int64, error) {
+	ctx, cancel, err := newBrowserContext()
+	if err != nil {
+		return "", 0, fmt.Errorf("browser fetch failed for %s: %w", imgURL, err)
+	}
 	defer cancel()
-	var buf []byte
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(imgURL),
-		chromedp.WaitVisible("img,body", chromedp.ByQuery),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			// Download the image bytes
-			req, err := http.NewRequest("GET", imgURL, nil)
-			if err != nil {
-				return err
-			}
-			req.Header.Set("User-Agent", RandomUserAgent())
-			req.Header.Set("Accept", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			buf, err = io.ReadAll(resp.Body)
-			return err
-		}),
-	)
+	ctx, cancel = context.WithTimeout(ctx, browserFetchTimeout)
+	defer cancel()
+	var host string
+	if u, err := url.Parse(imgURL); err == nil {
+		host = u.Hostname()
+	}
+	var res browserFetchResult
+	if pageURL != "" {
+		err = chromedp.Run(ctx,
+			chromedp.Navigate(pageURL),
+			chromedp.WaitReady("body", chromedp.ByQuery),
+			capBrowserBandwidth(host),
+			fetchInPage(imgURL, &res),
+		)
+	}
+	if pageURL == "" || err != nil {
+		err = chromedp.Run(ctx,
+			chromedp.Navigate(imgURL),
+			chromedp.WaitReady("body", chromedp.ByQuery),
+			capBrowserBandwidth(host),
+			fetchInPage(imgURL, &res),
+		)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", 0, &TimeoutError{URL: imgURL, Err: fmt.Errorf("browser fetch took over %v", browserFetchTimeout)}
+	}
 	if err != nil {
-		return err
+		return "", 0, fmt.Errorf("browser fetch failed for %s: %w", imgURL, err)
 	}
-	ext := filepath.Ext(imgURL)
-	if len(ext) == 0 || len(ext) > 10 {
-		ext = ".jpg"
+	buf, err := base64.StdEncoding.DecodeString(res.Data)
+	if err != nil {
+		return "", 0, &DecodeError{URL: imgURL, Err: err}
 	}
-	rnd := make([]byte, 4)
-	_, _ = crand.Read(rnd)
-	rndStr := hex.EncodeToString(rnd)
-	fname := fmt.Sprintf("file_%s_%03d%s", rndStr, idx, ext)
-	fpath := filepath.Join(outDir, fname)
-	return os.WriteFile(fpath, buf, 0644)
+	// The tab already fetched at the capped speed; the bytes still count against the shared
+	// buckets so downloads running next to it slow down.
+	bandwidth.reserve(host, len(buf))
+	ins, err := inspectBytes(imgURL, buf[:min(len(buf), inspectLen)], res.Type, int64(len(buf)))
+	if err != nil {
+		return "", 0, err
+	}
+	ft := ins.Type
+	reportMismatch(imgURL, ft)
+	fpath, err := saveBytes(outDir, NameVars{PageURL: pageURL, URL: imgURL, Idx: idx, Ext: ft.Ext}, buf)
+	if err != nil {
+		return "", 0, err
+	}
+	fmt.Printf("Saved via browser: %s (%s, %d bytes)\n", fpath, ft.MIME, len(buf))
+	return fpath, int64(len(buf)), nil
 }
 
-// DownloadFile downloads a file from the given URL to the specified directory, using a random name with an increasing number suffix.
+// capBrowserBandwidth limits the tab's download speed to the bandwidth cap for host, so the
+// browser fallback fetches no faster than a normal download.
+func capBrowserBandwidth(host string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		rate := float64(bandwidth.rateFor(host))
+		if rate == 0 {
+			return nil
+		}
+		return network.EmulateNetworkConditions(false, 0, rate, -1).Do(ctx)
+	})
+}
+
+// DownloadFile downloads a file from the given URL to the specified directory, named by the current name template.
 // Now with retry, user agent rotation, and rate limiting.
 func DownloadFile(url, outDir string, idx int) error {
+	defer flushDownloadState()
+	return downloadFile(url, outDir, idx)
+}
+
+// downloadFile is DownloadFile without writing the cache and index, for use within a batch.
+func downloadFile(url, outDir string, idx int) error {
 	const (
-		maxRetries  = 5
-		minDelay    = 500 * time.Millisecond
-		maxDelay    = 10 * time.Second
-		minFileSize = 50 * 1024 // 50KB
+		maxRetries = 5
+		minDelay   = 500 * time.Millisecond
+		maxDelay   = 10 * time.Second
 	)
+	client := newDownloadClient(nil, HTTPTransport())
 	var lastErr error
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		// Rate limiting: sleep before each attempt (jittered)
@@ -296,26 +414,17 @@ func DownloadFile(url, outDir string, idx int) error {
 			re := regexp.MustCompile(`^data:image/(\w+);base64,(.*)$`)
 			matches := re.FindStringSubmatch(url)
 			if len(matches) != 3 {
-				return fmt.Errorf("invalid data url format")
+				return &DecodeError{URL: "data URL", Err: errors.New("invalid data url format")}
 			}
-			ext := "." + matches[1]
 			data, err := base64.StdEncoding.DecodeString(matches[2])
 			if err != nil {
-				return fmt.Errorf("base64 decode error: %w", err)
+				return &DecodeError{URL: "data URL", Err: err}
 			}
-			rnd := make([]byte, 4)
-			_, _ = crand.Read(rnd)
-			rndStr := hex.EncodeToString(rnd)
-			fname := fmt.Sprintf("file_%s_%03d%s", rndStr, idx, ext)
-			fpath := filepath.Join(outDir, fname)
-			f, err := os.Create(fpath)
+			ft, err := detectType(data[:min(len(data), sniffLen)], "image/"+matches[1], "")
 			if err != nil {
-				lastErr = err
-				continue
+				return err
 			}
-			defer f.Close()
-			_, err = f.Write(data)
-			if err != nil {
+			if _, err := saveBytes(outDir, NameVars{URL: url, Idx: idx, Ext: ft.Ext}, data); err != nil {
 				lastErr = err
 				continue
 			}
@@ -328,101 +437,68 @@ func DownloadFile(url, outDir string, idx int) error {
 			continue
 		}
 		req.Header.Set("User-Agent", RandomUserAgent())
-		client := NewClient()
-		resp, err := client.Do(req)
+		dl, err := beginResumable(client, req, outDir)
+		if errors.Is(err, errAlreadyDownloaded) {
+			return nil
+		}
 		if err != nil {
 			lastErr = err
 			continue
 		}
+		resp := dl.resp
 		defer resp.Body.Close()
 		// Retry on 429, 403, 5xx, and timeouts
 		if resp.StatusCode == 429 || resp.StatusCode == 403 || (resp.StatusCode >= 500 && resp.StatusCode < 600) {
-			lastErr = fmt.Errorf("bad status: %s", resp.Status)
-			// Optionally, parse Retry-After header for 429
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if sec, err := strconv.Atoi(retryAfter); err == nil {
-					time.Sleep(time.Duration(sec) * time.Second)
-				}
-			}
+			lastErr = &HTTPStatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+			// The shared limiter has slowed the host down and holds the next attempt until Retry-After
 			continue
 		}
 		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-			lastErr = fmt.Errorf("bad status: %s", resp.Status)
+			lastErr = &HTTPStatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
 			continue
 		}
-		rnd := make([]byte, 4)
-		_, _ = crand.Read(rnd)
-		rndStr := hex.EncodeToString(rnd)
-		ext := filepath.Ext(url)
-		// Map of common content types to file extensions
-		contentTypeToExt := map[string]string{
-			"image/jpeg":      ".jpg",
-			"image/png":       ".png",
-			"image/gif":       ".gif",
-			"image/webp":      ".webp",
-			"image/bmp":       ".bmp",
-			"image/tiff":      ".tiff",
-			"video/mp4":       ".mp4",
-			"video/webm":      ".webm",
-			"video/ogg":       ".ogv",
-			"video/quicktime": ".mov",
+		// Identify the file and apply the accept filter before writing; a rejection is final
+		ins, err := dl.inspect(throttle(req.URL.Hostname(), resp.Body), resp.Header.Get("Content-Type"), dl.state.Size)
+		if err != nil {
+			dl.discard()
+			return err
 		}
-		if len(ext) > 10 || len(ext) == 0 || ext == ".bin" {
-			ctype := resp.Header.Get("Content-Type")
-			if ctype != "" {
-				if semi := strings.Index(ctype, ";"); semi != -1 {
-					ctype = ctype[:semi]
-				}
-				if newExt, ok := contentTypeToExt[ctype]; ok {
-					ext = newExt
-				} else {
-					ext = ".bin"
-				}
-			} else {
-				ext = ".bin"
+		reportMismatch(url, ins.Type)
+		// Save to the part file; an interrupted copy is resumed on the next attempt
+		if _, err := dl.write(ins.body); err != nil {
+			if isFinal(err) {
+				dl.discard()
+				return err
 			}
-		}
-		fname := fmt.Sprintf("file_%s_%03d%s", rndStr, idx, ext)
-		fpath := filepath.Join(outDir, fname)
-		f, err := os.Create(fpath)
-		if err != nil {
 			lastErr = err
 			continue
 		}
-		defer f.Close()
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			lastErr = err
-			continue
+		if err := dl.checkSize(); err != nil {
+			dl.discard()
+			return err
 		}
-		// Check file size and delete if < 50 KB
-		info, err := f.Stat()
-		if err == nil && info.Size() < int64(minFileSize) {
-			f.Close()
-			os.Remove(fpath)
-			lastErr = fmt.Errorf("file %s deleted (size < 50KB)", fname)
+		if _, err := dl.finish(outDir, NameVars{Idx: idx, Ext: ins.Type.Ext}); err != nil {
+			lastErr = err
 			continue
 		}
 		return nil // success
 	}
-	return fmt.Errorf("download failed for %s after %d attempts: %v", url, maxRetries, lastErr)
+	return fmt.Errorf("download failed for %s after %d attempts: %w", url, maxRetries, lastErr)
 }
 
-// DownloadFilesConcurrently downloads up to 5 files at a time, with global rate limiting.
+// DownloadFilesConcurrently downloads up to 5 files at a time, paced per host by the shared limiter.
 func DownloadFilesConcurrently(urls []string, outDir string) {
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 5) // reduce concurrency for less blocking
 	var successCount int64
 	var mu sync.Mutex
-	rateLimit := time.Tick(350 * time.Millisecond) // global rate limit
 	for i, u := range urls {
-		<-rateLimit // throttle globally
 		wg.Add(1)
 		sem <- struct{}{} // acquire
 		go func(url string, idx int) {
 			defer wg.Done()
 			defer func() { <-sem }() // release
-			if err := DownloadFile(url, outDir, idx); err != nil {
+			if err := downloadFile(url, outDir, idx); err != nil {
 				fmt.Println("Download error:", err)
 			} else {
 				mu.Lock()
@@ -432,5 +508,6 @@ func DownloadFilesConcurrently(urls []string, outDir string) {
 		}(u, i+1)
 	}
 	wg.Wait()
+	flushDownloadState()
 	fmt.Printf("Successfully downloaded %d file(s).\n", successCount)
 }
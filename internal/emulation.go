@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+)
+
+// Geolocation is a fixed position reported to the page.
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy,omitempty"`
+}
+
+// EmulationProfile describes the device and locale a page is rendered as.
+type EmulationProfile struct {
+	Name              string  `json:"name"`
+	Width             int64   `json:"width,omitempty"`
+	Height            int64   `json:"height,omitempty"`
+	DeviceScaleFactor float64 `json:"device_scale_factor,omitempty"`
+	Mobile            bool    `json:"mobile,omitempty"`
+	Touch             bool    `json:"touch,omitempty"`
+	// UserAgent defaults to RandomUserAgent().
+	UserAgent string `json:"user_agent,omitempty"`
+	// AcceptLanguage also sets the page locale from its first tag, e.g. "de-DE,de;q=0.9".
+	AcceptLanguage string       `json:"accept_language,omitempty"`
+	Timezone       string       `json:"timezone,omitempty"` // IANA name, e.g. "Europe/Berlin"
+	Geolocation    *Geolocation `json:"geolocation,omitempty"`
+}
+
+// EmulationPresets are the built-in profiles selectable by name.
+var EmulationPresets = map[string]EmulationProfile{
+	"desktop": {
+		Name: "desktop", Width: 1920, Height: 1080, DeviceScaleFactor: 1,
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+	"mobile": {
+		Name: "mobile", Width: 390, Height: 844, DeviceScaleFactor: 3, Mobile: true, Touch: true,
+		UserAgent:      "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+	"tablet": {
+		Name: "tablet", Width: 820, Height: 1180, DeviceScaleFactor: 2, Mobile: true, Touch: true,
+		UserAgent:      "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+}
+
+// apply sets up the tab as the profile's device; it must run before navigation.
+func (p EmulationProfile) apply(pageURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if p.Width > 0 && p.Height > 0 {
+			scale := p.DeviceScaleFactor
+			if scale <= 0 {
+				scale = 1
+			}
+			if err := emulation.SetDeviceMetricsOverride(p.Width, p.Height, scale, p.Mobile).Do(ctx); err != nil {
+				return fmt.Errorf("device metrics: %w", err)
+			}
+		}
+		if p.Touch {
+			if err := emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(5).Do(ctx); err != nil {
+				return fmt.Errorf("touch: %w", err)
+			}
+		}
+		ua := p.UserAgent
+		if ua == "" {
+			ua = RandomUserAgent()
+		}
+		uaOverride := emulation.SetUserAgentOverride(ua)
+		if p.AcceptLanguage != "" {
+			uaOverride = uaOverride.WithAcceptLanguage(p.AcceptLanguage)
+		}
+		if err := uaOverride.Do(ctx); err != nil {
+			return fmt.Errorf("user agent: %w", err)
+		}
+		if p.AcceptLanguage != "" {
+			locale := strings.TrimSpace(strings.Split(strings.Split(p.AcceptLanguage, ",")[0], ";")[0])
+			if err := emulation.SetLocaleOverride().WithLocale(locale).Do(ctx); err != nil {
+				return fmt.Errorf("locale: %w", err)
+			}
+		}
+		if p.Timezone != "" {
+			if err := emulation.SetTimezoneOverride(p.Timezone).Do(ctx); err != nil {
+				return fmt.Errorf("timezone: %w", err)
+			}
+		}
+		if g := p.Geolocation; g != nil {
+			if u, err := url.Parse(pageURL); err == nil {
+				origin := u.Scheme + "://" + u.Host
+				grant := browser.GrantPermissions([]browser.PermissionType{browser.PermissionTypeGeolocation}).WithOrigin(origin)
+				if err := grant.Do(cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Browser)); err != nil {
+					return fmt.Errorf("geolocation permission: %w", err)
+				}
+			}
+			accuracy := g.Accuracy
+			if accuracy <= 0 {
+				accuracy = 50
+			}
+			err := emulation.SetGeolocationOverride().
+				WithLatitude(g.Latitude).
+				WithLongitude(g.Longitude).
+				WithAccuracy(accuracy).
+				Do(ctx)
+			if err != nil {
+				return fmt.Errorf("geolocation: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Escalation tiers a download can end up using, cheapest first.
+const (
+	MethodBasic       = "basic"        // plain request with browser-like headers
+	MethodCookies     = "cookies"      // request carried cookies picked up from the hosting page
+	MethodInsecureTLS = "insecure-tls" // retried without certificate verification
+	MethodBrowser     = "browser"      // fetched from inside a headless Chrome tab
+)
+
+// HTTPStatusError is a response with a status that is not a success.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("bad status for %s: %s", e.URL, e.Status)
+}
+
+// TimeoutError is a request that ran out of time.
+type TimeoutError struct {
+	URL string
+	Err error
+}
+
+func (e *TimeoutError) Error() string { return fmt.Sprintf("timeout fetching %s: %v", e.URL, e.Err) }
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// TLSError is a failed TLS handshake or certificate check.
+type TLSError struct {
+	URL string
+	Err error
+}
+
+func (e *TLSError) Error() string { return fmt.Sprintf("TLS error fetching %s: %v", e.URL, e.Err) }
+func (e *TLSError) Unwrap() error { return e.Err }
+
+// DNSError is a host name that did not resolve.
+type DNSError struct {
+	URL string
+	Err error
+}
+
+func (e *DNSError) Error() string { return fmt.Sprintf("DNS error fetching %s: %v", e.URL, e.Err) }
+func (e *DNSError) Unwrap() error { return e.Err }
+
+// TooSmallError is a download under the accept filter's byte minimum.
+type TooSmallError struct {
+	URL  string
+	Size int64
+	Min  int64
+}
+
+func (e *TooSmallError) Error() string {
+	return fmt.Sprintf("%s rejected: %d bytes is under the %d byte minimum", e.URL, e.Size, e.Min)
+}
+
+// FilteredError is returned for a download the accept filter turned down. It is final: the
+// same URL would be turned down again, so it is never retried.
+type FilteredError struct {
+	URL    string
+	Reason string
+}
+
+func (e *FilteredError) Error() string {
+	return fmt.Sprintf("%s rejected: %s", e.URL, e.Reason)
+}
+
+// DecodeError is a response whose body could not be turned into media: bad base64, or a page
+// (MIME set) where media was expected.
+type DecodeError struct {
+	URL  string
+	MIME string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	switch {
+	case e.MIME != "" && e.URL == "":
+		return fmt.Sprintf("got %s instead of media", e.MIME)
+	case e.MIME != "":
+		return fmt.Sprintf("%s: got %s instead of media", e.URL, e.MIME)
+	}
+	return fmt.Sprintf("decode error for %s: %v", e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// classifyError wraps a transport error from fetching rawurl in the matching typed error.
+func classifyError(rawurl string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &DNSError{URL: rawurl, Err: err}
+	}
+	if isTLSError(err) {
+		return &TLSError{URL: rawurl, Err: err}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{URL: rawurl, Err: err}
+	}
+	return err
+}
+
+// isTLSError reports whether err comes from the TLS layer or a certificate check.
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostErr      x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostErr) || errors.As(err, &invalidErr)
+}
+
+// errorCategory names the kind of a download error for summaries.
+func errorCategory(err error) string {
+	var (
+		statusErr   *HTTPStatusError
+		timeoutErr  *TimeoutError
+		tlsErr      *TLSError
+		dnsErr      *DNSError
+		smallErr    *TooSmallError
+		filteredErr *FilteredError
+		decodeErr   *DecodeError
+	)
+	switch {
+	case errors.As(err, &statusErr) && statusErr.StatusCode == 403:
+		return "403 Forbidden"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("HTTP %d", statusErr.StatusCode)
+	case errors.As(err, &timeoutErr):
+		return "Timeout"
+	case errors.As(err, &tlsErr):
+		return "SSL/TLS"
+	case errors.As(err, &dnsErr):
+		return "DNS"
+	case errors.As(err, &smallErr):
+		return "Too small"
+	case errors.As(err, &filteredErr):
+		return "Filtered"
+	case errors.As(err, &decodeErr):
+		return "Decode"
+	}
+	return "Other"
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}, "DNS"},
+		{"deadline", fmt.Errorf("read: %w", os.ErrDeadlineExceeded), "Timeout"},
+		{"unknown authority", fmt.Errorf("get: %w", x509.UnknownAuthorityError{}), "SSL/TLS"},
+		{"bad hostname", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "x"}, "SSL/TLS"},
+		{"expired", x509.CertificateInvalidError{Reason: x509.Expired}, "SSL/TLS"},
+		{"verification", &tls.CertificateVerificationError{Err: errors.New("bad")}, "SSL/TLS"},
+		{"record header", tls.RecordHeaderError{Msg: "bad record"}, "SSL/TLS"},
+		// Only typed TLS failures count: text that merely mentions TLS does not.
+		{"tls in text", errors.New("tls: something odd"), "Other"},
+		{"http to https", errors.New("http: server gave HTTP response to HTTPS client"), "Other"},
+		{"reset", errors.New("connection reset by peer"), "Other"},
+	}
+	for _, tt := range tests {
+		if got := errorCategory(classifyError("http://x/a.jpg", tt.err)); got != tt.want {
+			t.Errorf("%s: category = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if classifyError("http://x/a.jpg", nil) != nil {
+		t.Error("classifyError(nil) is not nil")
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&HTTPStatusError{StatusCode: 403, Status: "403 Forbidden"}, "403 Forbidden"},
+		{fmt.Errorf("advanced download failed: %w", &HTTPStatusError{StatusCode: 404}), "HTTP 404"},
+		{&TimeoutError{Err: os.ErrDeadlineExceeded}, "Timeout"},
+		{&TLSError{Err: errors.New("x")}, "SSL/TLS"},
+		{&DNSError{Err: errors.New("x")}, "DNS"},
+		{&TooSmallError{Size: 1, Min: 2}, "Too small"},
+		{&FilteredError{Reason: "x"}, "Filtered"},
+		{&DecodeError{MIME: "text/html"}, "Decode"},
+		{errors.New("x"), "Other"},
+	}
+	for _, tt := range tests {
+		if got := errorCategory(tt.err); got != tt.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTLSErrorFromServer(t *testing.T) {
+	fastHosts(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// A client that does not trust the test certificate.
+	client := &http.Client{Transport: &http.Transport{}}
+	req, _ := http.NewRequest("GET", srv.URL+"/a.jpg", nil)
+	_, err := beginResumable(client, req, t.TempDir())
+	var tlsErr *TLSError
+	if !errors.As(err, &tlsErr) {
+		t.Errorf("untrusted certificate: err = %v, want a *TLSError", err)
+	}
+}
+
+func TestTimeoutWhileReadingBody(t *testing.T) {
+	fastHosts(t)
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		w.Write(testBody(1000))
+		w.(http.Flusher).Flush()
+		<-stop // stall mid-body
+	}))
+	defer srv.Close()
+	defer close(stop)
+	client := &http.Client{Transport: idleTimeoutTransport{base: srv.Client().Transport, idle: 200 * time.Millisecond}}
+	req, _ := http.NewRequest("GET", srv.URL+"/a.jpg", nil)
+	d, err := beginResumable(client, req, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.resp.Body.Close()
+	_, err = d.write(d.resp.Body)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("stalled body: err = %v, want a *TimeoutError", err)
+	}
+	if d.size() != 1000 {
+		t.Errorf("part has %d bytes, want the 1000 received before the stall", d.size())
+	}
+}
+
+func TestIdleTimeoutIgnoresSlowReader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody(3000))
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: idleTimeoutTransport{base: srv.Client().Transport, idle: 50 * time.Millisecond}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// A caller pausing between reads, as bandwidth throttling does, is not a stall.
+	buf := make([]byte, 1000)
+	total := 0
+	for {
+		time.Sleep(80 * time.Millisecond)
+		n, err := resp.Body.Read(buf)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("after %d bytes: %v", total, err)
+		}
+	}
+	if total != 3000 {
+		t.Errorf("read %d bytes, want 3000", total)
+	}
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// Fragment sources.
+const (
+	FragmentFrame  = "frame"  // an iframe's document
+	FragmentShadow = "shadow" // the contents of an open shadow root
+)
+
+// Fragment is a piece of rendered markup that OuterHTML("html") leaves out, with the base
+// URL its relative links resolve against.
+type Fragment struct {
+	Source  string `json:"source"`
+	BaseURL string `json:"base"`
+	HTML    string `json:"html"`
+}
+
+// serializeFragmentsJS collects the document (when %t) and every open shadow root in it,
+// descending into nested shadow roots.
+const serializeFragmentsJS = `(() => {
+	const out = [];
+	if (%t && document.documentElement) {
+		out.push({source: 'frame', base: document.baseURI, html: document.documentElement.outerHTML});
+	}
+	const walk = (root) => {
+		for (const el of root.querySelectorAll('*')) {
+			if (el.shadowRoot) {
+				out.push({source: 'shadow', base: document.baseURI, html: el.shadowRoot.innerHTML});
+				walk(el.shadowRoot);
+			}
+		}
+	};
+	walk(document);
+	return out;
+})()`
+
+// collectFragments gathers shadow roots of the main document and the documents (and shadow
+// roots) of every frame. Frames that cannot be read are reported in warnings.
+func collectFragments(ctx context.Context, res *RenderResult) error {
+	var main []Fragment
+	if err := chromedp.Evaluate(fmt.Sprintf(serializeFragmentsJS, false), &main).Do(ctx); err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("shadow roots: %v", err))
+	}
+	res.Fragments = append(res.Fragments, main...)
+
+	tree, err := page.GetFrameTree().Do(ctx)
+	if err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("frame tree: %v", err))
+		return nil
+	}
+	var frames []*cdp.Frame
+	var walk func(t *page.FrameTree)
+	walk = func(t *page.FrameTree) {
+		for _, child := range t.ChildFrames {
+			frames = append(frames, child.Frame)
+			walk(child)
+		}
+	}
+	walk(tree)
+
+	for _, f := range frames {
+		frags, err := frameFragments(ctx, f.ID)
+		if err != nil {
+			// Out-of-process (cross-site) frames live in their own target.
+			frags, err = outOfProcessFrameFragments(ctx, f.ID)
+		}
+		if err != nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("frame %s: %v", f.URL, err))
+			continue
+		}
+		res.Fragments = append(res.Fragments, frags...)
+	}
+	return nil
+}
+
+// frameFragments serializes a frame from an isolated world, so page scripts can't interfere.
+func frameFragments(ctx context.Context, id cdp.FrameID) ([]Fragment, error) {
+	execID, err := page.CreateIsolatedWorld(id).WithWorldName("img-scraper").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj, exp, err := runtime.Evaluate(fmt.Sprintf(serializeFragmentsJS, true)).
+		WithContextID(execID).
+		WithReturnByValue(true).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exp != nil {
+		return nil, exp
+	}
+	var frags []Fragment
+	if err := json.Unmarshal(obj.Value, &frags); err != nil {
+		return nil, err
+	}
+	return frags, nil
+}
+
+// outOfProcessFrameFragments attaches to the iframe target whose ID matches the frame ID.
+func outOfProcessFrameFragments(ctx context.Context, id cdp.FrameID) ([]Fragment, error) {
+	targets, err := chromedp.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Type != "iframe" || string(t.TargetID) != string(id) {
+			continue
+		}
+		fctx, cancel := chromedp.NewContext(ctx, chromedp.WithTargetID(target.ID(id)))
+		defer releaseTarget(fctx, cancel)
+		var frags []Fragment
+		if err := chromedp.Run(fctx, chromedp.Evaluate(fmt.Sprintf(serializeFragmentsJS, true), &frags)); err != nil {
+			return nil, err
+		}
+		return frags, nil
+	}
+	return nil, fmt.Errorf("frame document not accessible")
+}
+
+// releaseTarget detaches from a target attached with WithTargetID and cancels its context.
+// Cancelling alone would close the target, and the iframe belongs to the page, which later
+// steps and captures still need whole.
+func releaseTarget(ctx context.Context, cancel context.CancelFunc) {
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		if id := c.Target.SessionID; id != "" {
+			_ = target.DetachFromTarget().WithSessionID(id).Do(cdp.WithExecutor(ctx, c.Browser))
+		}
+		c.Target = nil // nothing left for the context to close
+	}
+	cancel()
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// httpCacheName is the cache index kept in the output root.
+	httpCacheName = ".http-cache.json"
+	// httpCacheDir holds the bodies of cached pages.
+	httpCacheDir = ".http-cache"
+)
+
+// errNotModified is returned when the server confirmed (304) that the copy on disk is current.
+var errNotModified = fmt.Errorf("not modified: %w", errAlreadyDownloaded)
+
+// CacheEntry is what is remembered about one URL between runs.
+type CacheEntry struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	File         string    `json:"file,omitempty"` // downloaded file, or cached page body under httpCacheDir
+	Page         bool      `json:"page,omitempty"`
+	Size         int64     `json:"size"`
+	StoredAt     time.Time `json:"stored_at"`
+	UsedAt       time.Time `json:"used_at"`
+}
+
+// HTTPCache keeps validators for every fetched URL, and the bodies of fetched pages, so repeat
+// scrapes can ask "has this changed?" instead of downloading again.
+type HTTPCache struct {
+	Entries map[string]*CacheEntry `json:"entries"`
+
+	dir      string
+	maxAge   time.Duration
+	maxBytes int64 // cap on cached page bodies
+	dirty    bool  // changed since the index was last written; see Flush
+	mu       sync.Mutex
+}
+
+var (
+	httpCacheMu sync.RWMutex
+	httpCache   *HTTPCache
+)
+
+// OpenHTTPCache loads the cache in dir. Entries older than maxAge are dropped, and the oldest
+// page bodies go once they add up to more than maxBytes; zero disables either limit.
+func OpenHTTPCache(dir string, maxAge time.Duration, maxBytes int64) (*HTTPCache, error) {
+	c := &HTTPCache{Entries: map[string]*CacheEntry{}, dir: dir, maxAge: maxAge, maxBytes: maxBytes}
+	data, err := os.ReadFile(filepath.Join(dir, httpCacheName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parse http cache: %w", err)
+		}
+		if c.Entries == nil {
+			c.Entries = map[string]*CacheEntry{}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked()
+	return c, c.saveLocked()
+}
+
+// SetHTTPCache makes downloads and page fetches revalidate against c; nil disables it.
+func SetHTTPCache(c *HTTPCache) {
+	httpCacheMu.Lock()
+	httpCache = c
+	httpCacheMu.Unlock()
+}
+
+func currentHTTPCache() *HTTPCache {
+	httpCacheMu.RLock()
+	defer httpCacheMu.RUnlock()
+	return httpCache
+}
+
+// conditional adds If-None-Match / If-Modified-Since to req when the URL was fetched before
+// and its content is still on disk. It reports whether it did.
+func (c *HTTPCache) conditional(req *http.Request) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Entries[req.URL.String()]
+	if !ok || (e.ETag == "" && e.LastModified == "") {
+		return false
+	}
+	if _, err := os.Stat(c.path(e)); err != nil {
+		return false
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+	return true
+}
+
+// hit marks the entry for rawurl as used and returns it.
+func (c *HTTPCache) hit(rawurl string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Entries[rawurl]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	e.UsedAt = time.Now()
+	c.dirty = true
+	return *e, true
+}
+
+// path resolves where an entry's content lives.
+func (c *HTTPCache) path(e *CacheEntry) string {
+	if e.Page {
+		return filepath.Join(c.dir, httpCacheDir, e.File)
+	}
+	return e.File
+}
+
+// storeDownload remembers the validators of a finished download saved at file.
+func (c *HTTPCache) storeDownload(rawurl, etag, lastModified, sum, file string, size int64) error {
+	if etag == "" && lastModified == "" {
+		return nil // nothing to revalidate with
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[rawurl] = &CacheEntry{
+		ETag: etag, LastModified: lastModified, SHA256: sum, File: abs, Size: size,
+		StoredAt: now, UsedAt: now,
+	}
+	c.dirty = true
+	c.evictLocked()
+	return nil
+}
+
+// storePage keeps a fetched page body so a later 304 can be answered from disk.
+func (c *HTTPCache) storePage(rawurl string, header http.Header, body []byte) error {
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+	name := partKey(rawurl) + ".html"
+	if err := os.MkdirAll(filepath.Join(c.dir, httpCacheDir), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, httpCacheDir, name), body, 0644); err != nil {
+		return err
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[rawurl] = &CacheEntry{
+		ETag: etag, LastModified: lastModified, File: name, Page: true, Size: int64(len(body)),
+		StoredAt: now, UsedAt: now,
+	}
+	c.dirty = true
+	c.evictLocked()
+	return nil
+}
+
+// Flush writes the index if anything changed since the last write. Entries and hits are only
+// kept in memory as they happen, so a batch costs one write rather than one per URL.
+func (c *HTTPCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	if err := c.saveLocked(); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// flushHTTPCache writes the shared cache, if any, reporting failures in the log.
+func flushHTTPCache() {
+	if c := currentHTTPCache(); c != nil {
+		if err := c.Flush(); err != nil {
+			fmt.Printf("Failed to save HTTP cache: %v\n", err)
+		}
+	}
+}
+
+// page returns the cached body of a page.
+func (c *HTTPCache) page(rawurl string) ([]byte, bool) {
+	e, ok := c.hit(rawurl)
+	if !ok || !e.Page {
+		return nil, false
+	}
+	body, err := os.ReadFile(c.path(&e))
+	return body, err == nil
+}
+
+// evictLocked drops entries not used within maxAge, then the least recently used page bodies
+// until they fit in maxBytes.
+func (c *HTTPCache) evictLocked() {
+	now := time.Now()
+	var pages []string
+	var pageBytes int64
+	for u, e := range c.Entries {
+		if c.maxAge > 0 && now.Sub(e.UsedAt) > c.maxAge {
+			c.dropLocked(u)
+			continue
+		}
+		if e.Page {
+			pages = append(pages, u)
+			pageBytes += e.Size
+		}
+	}
+	if c.maxBytes <= 0 || pageBytes <= c.maxBytes {
+		return
+	}
+	sort.Slice(pages, func(i, j int) bool { return c.Entries[pages[i]].UsedAt.Before(c.Entries[pages[j]].UsedAt) })
+	for _, u := range pages {
+		if pageBytes <= c.maxBytes {
+			break
+		}
+		pageBytes -= c.Entries[u].Size
+		c.dropLocked(u)
+	}
+}
+
+func (c *HTTPCache) dropLocked(rawurl string) {
+	if e := c.Entries[rawurl]; e.Page {
+		os.Remove(c.path(e))
+	}
+	delete(c.Entries, rawurl)
+}
+
+func (c *HTTPCache) saveLocked() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, httpCacheName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, httpCacheName))
+}
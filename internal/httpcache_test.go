@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withHTTPCache opens a cache in a temporary directory and makes it the shared one for the
+// length of a test.
+func withHTTPCache(t *testing.T, maxAge time.Duration, maxBytes int64) *HTTPCache {
+	t.Helper()
+	c, err := OpenHTTPCache(t.TempDir(), maxAge, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHTTPCache(c)
+	t.Cleanup(func() { SetHTTPCache(nil) })
+	return c
+}
+
+// savedEntries reads the cache index as written on disk.
+func savedEntries(t *testing.T, c *HTTPCache) map[string]*CacheEntry {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(c.dir, httpCacheName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved HTTPCache
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	return saved.Entries
+}
+
+func TestFetchStaticRevalidates(t *testing.T) {
+	fastHosts(t)
+	c := withHTTPCache(t, 0, 0)
+	const page = "<html><body><img src=a.jpg></body></html>"
+	var full, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"p1"`)
+		if r.Header.Get("If-None-Match") == `"p1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+	for i := 0; i < 3; i++ {
+		got, err := FetchStatic(srv.URL + "/gallery")
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		if got != page {
+			t.Fatalf("fetch %d returned %q", i, got)
+		}
+	}
+	if full.Load() != 1 || notModified.Load() != 2 {
+		t.Errorf("server sent %d full pages and %d 304s, want 1 and 2", full.Load(), notModified.Load())
+	}
+	if e := savedEntries(t, c)[srv.URL+"/gallery"]; e == nil || !e.Page || e.ETag != `"p1"` {
+		t.Errorf("saved entry = %+v", e)
+	}
+}
+
+func TestHTTPCacheFlush(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenHTTPCache(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "a.jpg")
+	os.WriteFile(file, []byte("jpeg"), 0644)
+	if err := c.storeDownload("http://x/a.jpg", `"e1"`, "", "sum", file, 4); err != nil {
+		t.Fatal(err)
+	}
+	if len(savedEntries(t, c)) != 0 {
+		t.Error("store wrote the index before Flush")
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	first := savedEntries(t, c)["http://x/a.jpg"]
+	if first == nil {
+		t.Fatal("entry missing after Flush")
+	}
+
+	// A hit is a use: after the flush, a reopened cache sees the new time.
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.hit("http://x/a.jpg"); !ok {
+		t.Fatal("no hit for a stored URL")
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenHTTPCache(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := reopened.Entries["http://x/a.jpg"]; e == nil || !e.UsedAt.After(first.UsedAt) {
+		t.Errorf("UsedAt not persisted: was %v, now %+v", first.UsedAt, e)
+	}
+
+	// Nothing changed: Flush leaves the file alone.
+	os.Remove(filepath.Join(dir, httpCacheName))
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, httpCacheName)); !os.IsNotExist(err) {
+		t.Error("Flush wrote an unchanged index")
+	}
+}
+
+func TestHTTPCacheEviction(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		maxBytes int64
+		entries  map[string]*CacheEntry
+		want     []string // URLs kept
+	}{
+		{
+			name:   "stale entries go",
+			maxAge: time.Hour,
+			entries: map[string]*CacheEntry{
+				"http://x/old":   {ETag: `"1"`, File: "old", UsedAt: now.Add(-2 * time.Hour)},
+				"http://x/fresh": {ETag: `"2"`, File: "fresh", UsedAt: now.Add(-time.Minute)},
+			},
+			want: []string{"http://x/fresh"},
+		},
+		{
+			name:     "least recently used pages go first",
+			maxBytes: 250,
+			entries: map[string]*CacheEntry{
+				"http://x/p1": {ETag: `"1"`, File: "p1.html", Page: true, Size: 100, UsedAt: now.Add(-3 * time.Minute)},
+				"http://x/p2": {ETag: `"2"`, File: "p2.html", Page: true, Size: 100, UsedAt: now.Add(-time.Minute)},
+				"http://x/p3": {ETag: `"3"`, File: "p3.html", Page: true, Size: 100, UsedAt: now.Add(-2 * time.Minute)},
+				"http://x/f":  {ETag: `"4"`, File: "f.jpg", Size: 5000, UsedAt: now.Add(-time.Hour)},
+			},
+			want: []string{"http://x/f", "http://x/p2", "http://x/p3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data, _ := json.Marshal(HTTPCache{Entries: tt.entries})
+			os.WriteFile(filepath.Join(dir, httpCacheName), data, 0644)
+			c, err := OpenHTTPCache(dir, tt.maxAge, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Entries) != len(tt.want) {
+				t.Errorf("kept %d entries, want %d", len(c.Entries), len(tt.want))
+			}
+			for _, u := range tt.want {
+				if c.Entries[u] == nil {
+					t.Errorf("%s evicted", u)
+				}
+			}
+		})
+	}
+}
+
+func TestSingleDownloadFlushes(t *testing.T) {
+	fastHosts(t)
+	withAcceptFilter(t, AcceptFilter{})
+	c := withHTTPCache(t, 0, 0)
+	ix, err := OpenContentIndex(c.dir, DedupSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetContentIndex(ix)
+	t.Cleanup(func() { SetContentIndex(nil) })
+	img := testPNG(t, 20, 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer srv.Close()
+	if err := AdvancedDownloadFile(srv.URL+"/a.png", "", c.dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if savedEntries(t, c)[srv.URL+"/a.png"] == nil {
+		t.Error("HTTP cache not written after a single download")
+	}
+	reopened, _ := OpenContentIndex(c.dir, DedupSkip)
+	if _, ok := reopened.Lookup(srv.URL + "/a.png"); !ok {
+		t.Error("content index not written after a single download")
+	}
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// InterceptConfig decides which requests a rendered page may make.
+type InterceptConfig struct {
+	// BlockTypes are CDP resource types to drop, e.g. "Font", "Media", "Stylesheet" (case-insensitive).
+	BlockTypes []string `json:"block_types,omitempty"`
+	// BlockPatterns are URL globs to drop; '*' matches any run of characters and '?' one character.
+	BlockPatterns []string `json:"block_patterns,omitempty"`
+	// AllowDomains, when set, drops requests to any other domain. Subdomains match, and the
+	// page's own host is always allowed.
+	AllowDomains []string `json:"allow_domains,omitempty"`
+	// Headers are added to every request the page makes.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Cookies are set for the page URL before navigating.
+	Cookies map[string]string `json:"cookies,omitempty"`
+}
+
+// DefaultIntercept blocks the usual dead weight: fonts, autoplaying media, and common ad and
+// analytics hosts.
+func DefaultIntercept() *InterceptConfig {
+	return &InterceptConfig{
+		BlockTypes: []string{"Font", "Media", "Ping", "CSPViolationReport"},
+		BlockPatterns: []string{
+			"*doubleclick.net*",
+			"*googlesyndication.com*",
+			"*google-analytics.com*",
+			"*googletagmanager.com*",
+			"*facebook.net*",
+			"*hotjar.com*",
+			"*scorecardresearch.com*",
+			"*adservice.*",
+		},
+	}
+}
+
+// InterceptStats counts what request interception let through and dropped.
+type InterceptStats struct {
+	Allowed int64 `json:"requests_allowed"`
+	Blocked int64 `json:"requests_blocked"`
+}
+
+// interceptor applies an InterceptConfig to a chromedp tab.
+type interceptor struct {
+	cfg      *InterceptConfig
+	pageHost string
+	types    map[string]bool
+	patterns []*regexp.Regexp
+	allowed  atomic.Int64
+	blocked  atomic.Int64
+}
+
+func newInterceptor(cfg *InterceptConfig, pageURL string) *interceptor {
+	ic := &interceptor{cfg: cfg, types: map[string]bool{}}
+	if u, err := url.Parse(pageURL); err == nil {
+		ic.pageHost = strings.ToLower(u.Hostname())
+	}
+	for _, t := range cfg.BlockTypes {
+		ic.types[strings.ToLower(t)] = true
+	}
+	for _, p := range cfg.BlockPatterns {
+		ic.patterns = append(ic.patterns, globToRegexp(p))
+	}
+	return ic
+}
+
+// globToRegexp turns a '*'/'?' wildcard pattern into an anchored regexp.
+func globToRegexp(glob string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("(?i)^" + quoted + "$")
+}
+
+// hostAllowed reports whether host is in the allowlist (or there is none).
+func (ic *interceptor) hostAllowed(host string) bool {
+	if len(ic.cfg.AllowDomains) == 0 || host == ic.pageHost {
+		return true
+	}
+	for _, d := range ic.cfg.AllowDomains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldBlock decides the fate of one paused request.
+func (ic *interceptor) shouldBlock(ev *fetch.EventRequestPaused) bool {
+	if ev.ResourceType == network.ResourceTypeDocument && ev.Request != nil {
+		// Never block the navigation itself.
+		if u, err := url.Parse(ev.Request.URL); err == nil && strings.ToLower(u.Hostname()) == ic.pageHost {
+			return false
+		}
+	}
+	if ic.types[strings.ToLower(string(ev.ResourceType))] {
+		return true
+	}
+	if ev.Request == nil {
+		return false
+	}
+	for _, re := range ic.patterns {
+		if re.MatchString(ev.Request.URL) {
+			return true
+		}
+	}
+	if u, err := url.Parse(ev.Request.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return !ic.hostAllowed(strings.ToLower(u.Hostname()))
+	}
+	return false
+}
+
+// install sets cookies and headers and starts intercepting; it must run before navigation.
+func (ic *interceptor) install(pageURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for name, value := range ic.cfg.Cookies {
+			if err := network.SetCookie(name, value).WithURL(pageURL).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if len(ic.cfg.Headers) > 0 {
+			headers := network.Headers{}
+			for k, v := range ic.cfg.Headers {
+				headers[k] = v
+			}
+			if err := network.SetExtraHTTPHeaders(headers).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if len(ic.types) == 0 && len(ic.patterns) == 0 && len(ic.cfg.AllowDomains) == 0 {
+			return nil
+		}
+		exec := cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target)
+		chromedp.ListenTarget(ctx, func(ev any) {
+			paused, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			// Listeners must not block; answer the browser from a goroutine.
+			go func() {
+				if ic.shouldBlock(paused) {
+					ic.blocked.Add(1)
+					_ = fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(exec)
+					return
+				}
+				ic.allowed.Add(1)
+				_ = fetch.ContinueRequest(paused.RequestID).Do(exec)
+			}()
+		})
+		return fetch.Enable().Do(ctx)
+	})
+}
+
+func (ic *interceptor) stats() InterceptStats {
+	return InterceptStats{Allowed: ic.allowed.Load(), Blocked: ic.blocked.Load()}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob, s string
+		want    bool
+	}{
+		{"*doubleclick.net*", "https://ad.doubleclick.net/x.js", true},
+		{"*doubleclick.net*", "https://DoubleClick.NET/", true},
+		{"*doubleclick.net*", "https://doubleclickXnet/", false},
+		{"*adservice.*", "https://adservice.google.com/a", true},
+		{"https://x.com/a?.js", "https://x.com/a1.js", true},
+		{"https://x.com/a?.js", "https://x.com/a12.js", false},
+		{"https://x.com/*.png", "https://x.com/img/a.png?w=1", false},
+		{"a+b(c)", "a+b(c)", true},
+	}
+	for _, tt := range tests {
+		if got := globToRegexp(tt.glob).MatchString(tt.s); got != tt.want {
+			t.Errorf("globToRegexp(%q) matches %q = %v, want %v", tt.glob, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	ic := newInterceptor(&InterceptConfig{AllowDomains: []string{"cdn.example.com", ".Images.Net"}}, "https://www.site.com/page")
+	tests := map[string]bool{
+		"www.site.com":        true, // the page's own host
+		"cdn.example.com":     true,
+		"a.cdn.example.com":   true,
+		"example.com":         false,
+		"evilcdn.example.com": false,
+		"images.net":          true,
+		"x.images.net":        true,
+		"other.org":           false,
+	}
+	for host, want := range tests {
+		if got := ic.hostAllowed(host); got != want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if open := newInterceptor(&InterceptConfig{}, "https://www.site.com/"); !open.hostAllowed("anything.org") {
+		t.Error("no allowlist: host refused")
+	}
+}
+
+func TestShouldBlock(t *testing.T) {
+	cfg := DefaultIntercept()
+	cfg.AllowDomains = []string{"cdn.site.com"}
+	ic := newInterceptor(cfg, "https://www.site.com/gallery")
+	req := func(typ network.ResourceType, u string) *fetch.EventRequestPaused {
+		return &fetch.EventRequestPaused{ResourceType: typ, Request: &network.Request{URL: u}}
+	}
+	tests := []struct {
+		name string
+		ev   *fetch.EventRequestPaused
+		want bool
+	}{
+		{"page navigation", req(network.ResourceTypeDocument, "https://www.site.com/gallery"), false},
+		{"image on the page host", req(network.ResourceTypeImage, "https://www.site.com/a.jpg"), false},
+		{"image on an allowed cdn", req(network.ResourceTypeImage, "https://img.cdn.site.com/a.jpg"), false},
+		{"image elsewhere", req(network.ResourceTypeImage, "https://other.org/a.jpg"), true},
+		{"blocked type", req(network.ResourceTypeFont, "https://www.site.com/f.woff2"), true},
+		{"blocked pattern", req(network.ResourceTypeScript, "https://www.googletagmanager.com/gtm.js"), true},
+		{"iframe document elsewhere", req(network.ResourceTypeDocument, "https://ads.other.org/frame"), true},
+		{"data url", req(network.ResourceTypeImage, "data:image/png;base64,AAAA"), false},
+		{"no request", &fetch.EventRequestPaused{ResourceType: network.ResourceTypeImage}, false},
+	}
+	for _, tt := range tests {
+		if got := ic.shouldBlock(tt.ev); got != tt.want {
+			t.Errorf("%s: shouldBlock = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestName is the file each job directory records itself in.
+const manifestName = "manifest.json"
+
+// Job statuses.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Artifact is a file a job produced besides the downloaded media, such as a page screenshot.
+type Artifact struct {
+	Kind  string `json:"kind"`
+	Path  string `json:"path"` // relative to the job directory
+	Bytes int64  `json:"bytes"`
+}
+
+// JobStats are counters collected while a job runs.
+type JobStats struct {
+	InterceptStats
+}
+
+// ContentRecord ties a URL to the content it downloaded as.
+type ContentRecord struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	File   string `json:"file"` // relative to the job directory; may point into another job
+	// Duplicate is set when the content was already on disk and no new copy was kept.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Job is one scrape of one page, with its own output directory and manifest.
+type Job struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"started_at"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	// RenderMode is how the page was actually fetched (static or browser) and RenderReason why.
+	RenderMode   string `json:"render_mode,omitempty"`
+	RenderReason string `json:"render_reason,omitempty"`
+	// IgnoredOptions are browser-only options left unused because the page was fetched statically.
+	IgnoredOptions []string `json:"ignored_options,omitempty"`
+	// Profiles are the emulation profiles the page was rendered under.
+	Profiles  []string   `json:"profiles,omitempty"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// Captured lists media saved straight from the browser, tagged with where it came from.
+	Captured []SavedMedia `json:"captured,omitempty"`
+	Stats    JobStats     `json:"stats"`
+	// RenderTimings is how long each render phase took.
+	RenderTimings []PhaseTiming `json:"render_timings,omitempty"`
+	// Candidates are the media URLs the job downloads; kept so an interrupted job can resume.
+	Candidates []string `json:"candidates,omitempty"`
+	// Contents maps each downloaded URL to the content it produced.
+	Contents []ContentRecord `json:"contents,omitempty"`
+	// Rejected are the downloads the accept filter turned down, with the reason for each.
+	Rejected []Rejection `json:"rejected,omitempty"`
+	// NearDuplicates are clusters of images that look alike; only the kept member stays in place.
+	NearDuplicates []NearDuplicateCluster `json:"near_duplicates,omitempty"`
+
+	Dir string `json:"-"`
+	mu  sync.Mutex
+}
+
+// NewJob creates a job directory under rootDir for pageURL.
+func NewJob(rootDir, pageURL string) (*Job, error) {
+	rnd := make([]byte, 3)
+	_, _ = crand.Read(rnd)
+	now := time.Now()
+	id := fmt.Sprintf("job_%s_%s", now.Format("20060102_150405"), hex.EncodeToString(rnd))
+	dir := filepath.Join(rootDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create job dir: %w", err)
+	}
+	j := &Job{ID: id, URL: pageURL, StartedAt: now, Status: JobRunning, Dir: dir}
+	return j, j.Save()
+}
+
+// LoadJob reads the manifest of an existing job.
+func LoadJob(rootDir, id string) (*Job, error) {
+	dir := filepath.Join(rootDir, filepath.Base(id))
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("parse manifest for %s: %w", id, err)
+	}
+	j.Dir = dir
+	return j, nil
+}
+
+// IncompleteJobs returns the jobs under rootDir that were still running when the process
+// stopped and have candidates left to download.
+func IncompleteJobs(rootDir string) ([]*Job, error) {
+	entries, err := os.ReadDir(rootDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		j, err := LoadJob(rootDir, e.Name())
+		if err != nil {
+			continue // not a job directory
+		}
+		if j.Status == JobRunning && len(j.Candidates) > 0 {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+// Finish marks the job done, records the content of its downloads and saves the manifest.
+func (j *Job) Finish() error {
+	j.mu.Lock()
+	j.Status = JobDone
+	j.recordContentsLocked()
+	j.mu.Unlock()
+	return j.Save()
+}
+
+// recordContentsLocked fills Contents from the content index for the job's candidates and
+// captured media.
+func (j *Job) recordContentsLocked() {
+	ix := currentContentIndex()
+	if ix == nil {
+		return
+	}
+	urls := append([]string(nil), j.Candidates...)
+	for _, m := range j.Captured {
+		urls = append(urls, m.URL)
+	}
+	j.Contents = nil
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		c, ok := ix.Lookup(u)
+		if !ok {
+			continue
+		}
+		file := ix.AbsPath(c.File)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(j.Dir, file); err == nil {
+			file = rel
+		}
+		j.Contents = append(j.Contents, ContentRecord{URL: u, SHA256: c.SHA256, File: file, Duplicate: c.Duplicate})
+	}
+}
+
+// Fail marks the job failed with err and saves the manifest.
+func (j *Job) Fail(err error) error {
+	j.mu.Lock()
+	j.Status, j.Error = JobFailed, err.Error()
+	j.mu.Unlock()
+	return j.Save()
+}
+
+// MediaFiles returns the downloaded files in the job directory, including those a name
+// template put in subfolders, leaving out the manifest, artifacts and working folders.
+func (j *Job) MediaFiles() ([]string, error) {
+	skip := map[string]bool{manifestName: true}
+	j.mu.Lock()
+	for _, a := range j.Artifacts {
+		skip[a.Path] = true
+	}
+	j.mu.Unlock()
+	var files []string
+	err := filepath.WalkDir(j.Dir, func(p string, e os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(j.Dir, p)
+		if strings.HasPrefix(e.Name(), ".") && p != j.Dir || rel == nearDupDir {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !e.IsDir() && !skip[rel] {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// WriteArtifact saves data as name inside the job directory and records it in the manifest.
+func (j *Job) WriteArtifact(kind, name string, data []byte) error {
+	if err := os.WriteFile(filepath.Join(j.Dir, name), data, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", kind, err)
+	}
+	a := Artifact{Kind: kind, Path: name, Bytes: int64(len(data))}
+	j.mu.Lock()
+	replaced := false
+	for i := range j.Artifacts {
+		if j.Artifacts[i].Path == name {
+			j.Artifacts[i], replaced = a, true // rewritten, e.g. the report of a resumed job
+		}
+	}
+	if !replaced {
+		j.Artifacts = append(j.Artifacts, a)
+	}
+	j.mu.Unlock()
+	return j.Save()
+}
+
+// SaveReport records the outcome of the job's downloads: rejections go in the manifest and
+// the per-URL results are written as report.json and report.csv.
+func (j *Job) SaveReport(b *BatchResult) error {
+	j.mu.Lock()
+	j.Rejected = b.Rejections()
+	j.mu.Unlock()
+	data, err := b.JSON()
+	if err != nil {
+		return err
+	}
+	if err := j.WriteArtifact("report", ReportJSON, data); err != nil {
+		return err
+	}
+	if data, err = b.CSV(); err != nil {
+		return err
+	}
+	return j.WriteArtifact("report", ReportCSV, data)
+}
+
+// Save writes the job manifest.
+func (j *Job) Save() error {
+	j.mu.Lock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.Dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(j.Dir, manifestName))
+}
@@ -0,0 +1,262 @@
+package internal
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultNameTemplate reproduces the historical random file names.
+const DefaultNameTemplate = "file_{rand}_{idx}{ext}"
+
+// maxSegmentLen caps each directory or file name produced by a template.
+const maxSegmentLen = 120
+
+// nameVarRe matches a template variable such as {host} or {sha256:8}.
+var nameVarRe = regexp.MustCompile(`\{([a-z_0-9]+)(?::(\d+))?\}`)
+
+// nameVars are the variables a template may use.
+var nameVars = map[string]bool{
+	"host": true, "page_slug": true, "url_path": true, "basename": true, "sha256": true,
+	"idx": true, "ext": true, "date": true, "width": true, "height": true, "rand": true,
+}
+
+// NameVars is what is known about a finished download when it is named.
+type NameVars struct {
+	PageURL string
+	URL     string
+	Idx     int
+	Ext     string // with the leading dot
+	SHA256  string
+	Width   int
+	Height  int
+	Time    time.Time
+}
+
+// NameTemplate turns NameVars into a relative output path, e.g. "{host}/{url_path}/{basename}{ext}".
+type NameTemplate struct {
+	raw string
+}
+
+var (
+	nameTemplateMu sync.RWMutex
+	nameTemplate   = &NameTemplate{raw: DefaultNameTemplate}
+	// storeMu serializes picking a free name and moving the file there.
+	storeMu sync.Mutex
+)
+
+// ParseNameTemplate checks that every variable in s is known.
+func ParseNameTemplate(s string) (*NameTemplate, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("empty name template")
+	}
+	for _, m := range nameVarRe.FindAllStringSubmatch(s, -1) {
+		if !nameVars[m[1]] {
+			return nil, fmt.Errorf("unknown variable {%s} in name template", m[1])
+		}
+		if m[2] != "" && m[1] != "sha256" {
+			return nil, fmt.Errorf("variable {%s} takes no length", m[1])
+		}
+	}
+	return &NameTemplate{raw: s}, nil
+}
+
+// SetNameTemplate selects the template used for every saved file.
+func SetNameTemplate(t *NameTemplate) {
+	nameTemplateMu.Lock()
+	nameTemplate = t
+	nameTemplateMu.Unlock()
+}
+
+func currentNameTemplate() *NameTemplate {
+	nameTemplateMu.RLock()
+	defer nameTemplateMu.RUnlock()
+	return nameTemplate
+}
+
+// Render fills in the template and returns a sanitized path relative to the output directory.
+// Every segment is cleaned, so no value can climb out of the directory or name a hidden file.
+func (t *NameTemplate) Render(v NameVars) string {
+	u, _ := url.Parse(v.URL)
+	if u == nil {
+		u = &url.URL{}
+	}
+	if v.Time.IsZero() {
+		v.Time = time.Now()
+	}
+	out := nameVarRe.ReplaceAllStringFunc(t.raw, func(tok string) string {
+		m := nameVarRe.FindStringSubmatch(tok)
+		var val string
+		switch m[1] {
+		case "host":
+			val = u.Hostname()
+		case "page_slug":
+			val = pageSlug(v.PageURL)
+		case "url_path":
+			// The one variable allowed to create directories.
+			return path.Dir(strings.TrimPrefix(u.Path, "/"))
+		case "basename":
+			base := path.Base(u.Path)
+			val = strings.TrimSuffix(base, path.Ext(base))
+			if val == "" || val == "." || val == "/" {
+				val = "file"
+			}
+		case "sha256":
+			val = v.SHA256
+			if n, err := strconv.Atoi(m[2]); err == nil && n < len(val) {
+				val = val[:n]
+			}
+		case "idx":
+			val = fmt.Sprintf("%03d", v.Idx)
+		case "ext":
+			val = v.Ext
+		case "date":
+			val = v.Time.Format("2006-01-02")
+		case "width":
+			val = strconv.Itoa(v.Width)
+		case "height":
+			val = strconv.Itoa(v.Height)
+		case "rand":
+			rnd := make([]byte, 4)
+			_, _ = crand.Read(rnd)
+			val = hex.EncodeToString(rnd)
+		}
+		return strings.ReplaceAll(val, "/", "_")
+	})
+	var segs []string
+	for _, s := range strings.Split(out, "/") {
+		if s = sanitizeSegment(s); s != "" {
+			segs = append(segs, s)
+		}
+	}
+	if len(segs) == 0 {
+		return "file" + sanitizeSegment(v.Ext)
+	}
+	return filepath.Join(segs...)
+}
+
+// sanitizeSegment keeps letters, digits, '.', '-' and '_', drops leading dots and caps the
+// length while keeping the extension.
+func sanitizeSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+	s = strings.TrimLeft(s, ".")
+	if len(s) > maxSegmentLen {
+		ext := path.Ext(s)
+		if len(ext) > 10 {
+			ext = ""
+		}
+		s = strings.ToValidUTF8(s[:maxSegmentLen-len(ext)], "") + ext
+	}
+	return s
+}
+
+// pageSlug turns a page URL into something like "example.com-gallery-cats".
+func pageSlug(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Host == "" {
+		return "page"
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(u.Hostname() + u.Path) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.Trim(b.String(), "-.")
+	if len(slug) > 80 {
+		slug = strings.ToValidUTF8(slug[:80], "")
+	}
+	return slug
+}
+
+// imageSize reads just the image header of a file; 0x0 if it is not a decodable image.
+func imageSize(path string) (int, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// resolveCollision picks the path to store content sum at, given the name the template asked
+// for. A taken name gets the content hash appended, so the outcome does not depend on which
+// download finished first. same reports that the chosen file already holds this content. It
+// fails rather than hand back a name taken by other content.
+func resolveCollision(dest, sum string) (string, bool, error) {
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	candidates := []string{dest}
+	if sum != "" {
+		candidates = append(candidates, base+"_"+sum[:8]+ext, base+"_"+sum+ext)
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); os.IsNotExist(err) {
+			return c, false, nil
+		}
+		if existing, err := hashFile(c); err == nil && existing == sum {
+			return c, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("no free name for %s: taken by other content", dest)
+}
+
+// StoreFile names the finished file src with the current template and moves it under outDir,
+// deduplicating through the content index. It returns the file now holding the content.
+func StoreFile(src, outDir string, v NameVars) (string, error) {
+	if v.SHA256 == "" {
+		sum, err := hashFile(src)
+		if err != nil {
+			return "", err
+		}
+		v.SHA256 = sum
+	}
+	if v.Width == 0 && v.Height == 0 {
+		v.Width, v.Height = imageSize(src)
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	dest, same, err := resolveCollision(filepath.Join(outDir, currentNameTemplate().Render(v)), v.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if same {
+		// A rerun produced the very same file under the very same name.
+		if err := os.Remove(src); err != nil {
+			return "", err
+		}
+		if ix := currentContentIndex(); ix != nil {
+			return dest, ix.record(v.URL, dest, v.SHA256)
+		}
+		return dest, nil
+	}
+	return placeFile(v.URL, src, dest, v.SHA256)
+}
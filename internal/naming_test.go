@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{DefaultNameTemplate, true},
+		{"{host}/{url_path}/{basename}_{sha256:8}{ext}", true},
+		{"{page_slug}/{date}/{width}x{height}_{idx}{ext}", true},
+		{"", false},
+		{"{nope}{ext}", false},
+		{"{idx:3}{ext}", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseNameTemplate(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseNameTemplate(%q) error = %v, want valid %v", tt.in, err, tt.valid)
+		}
+	}
+}
+
+func TestNameTemplateRender(t *testing.T) {
+	v := NameVars{
+		URL:     "https://cdn.example.com/img/2024/cat photo.jpg?w=800",
+		PageURL: "https://example.com/gallery/cats/",
+		Idx:     7,
+		Ext:     ".jpg",
+		SHA256:  "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Width:   800,
+		Height:  600,
+		Time:    time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		tmpl string
+		v    NameVars
+		want string
+	}{
+		{"{host}/{url_path}/{basename}_{sha256:8}{ext}", v, "cdn.example.com/img/2024/cat_photo_01234567.jpg"},
+		{"{page_slug}/{idx}{ext}", v, "example.com-gallery-cats/007.jpg"},
+		{"{date}_{width}x{height}{ext}", v, "2024-03-09_800x600.jpg"},
+		{"{sha256}{ext}", v, v.SHA256 + ".jpg"},
+		// Nothing climbs out of the output directory or names a hidden file.
+		{"{url_path}/{basename}{ext}", NameVars{URL: "https://x.com/a/../../../etc/passwd", Ext: ".bin"}, "etc/passwd.bin"},
+		{"../{basename}{ext}", NameVars{URL: "https://x.com/.secret.jpg", Ext: ".jpg"}, "secret.jpg"},
+		{"{basename}{ext}", NameVars{URL: "https://x.com/", Ext: ".png"}, "file.png"},
+		{"{basename}", NameVars{URL: "https://x.com/..", Ext: ".png"}, "file"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseNameTemplate(tt.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.Render(tt.v); got != filepath.FromSlash(tt.want) {
+			t.Errorf("Render(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, []byte(content), 0644)
+		return p
+	}
+	sumA, _ := hashFile(write("a-src", "content A"))
+	sumB, _ := hashFile(write("b-src", "content B"))
+
+	dest := filepath.Join(dir, "img.jpg")
+	if got, same, err := resolveCollision(dest, sumA); err != nil || got != dest || same {
+		t.Errorf("free name: got %q, %v, %v", got, same, err)
+	}
+	write("img.jpg", "content A")
+	if got, same, err := resolveCollision(dest, sumA); err != nil || got != dest || !same {
+		t.Errorf("same content: got %q, %v, %v; want the existing file", got, same, err)
+	}
+	short := filepath.Join(dir, "img_"+sumB[:8]+".jpg")
+	if got, same, err := resolveCollision(dest, sumB); err != nil || got != short || same {
+		t.Errorf("other content: got %q, %v, %v; want %q", got, same, err, short)
+	}
+	// Every candidate taken by other content: an error, never a path to overwrite.
+	write("img_"+sumB[:8]+".jpg", "content C")
+	write("img_"+sumB+".jpg", "content D")
+	if got, _, err := resolveCollision(dest, sumB); err == nil {
+		t.Errorf("all names taken: got %q, want an error", got)
+	}
+}
+
+func TestStoreFileIsIdempotent(t *testing.T) {
+	tmpl, _ := ParseNameTemplate("{host}/{basename}_{sha256:8}{ext}")
+	SetNameTemplate(tmpl)
+	t.Cleanup(func() { SetNameTemplate(&NameTemplate{raw: DefaultNameTemplate}) })
+	outDir := t.TempDir()
+	v := NameVars{URL: "https://x.com/pics/cat.jpg", Ext: ".jpg"}
+	var paths []string
+	for i := 0; i < 2; i++ {
+		src := filepath.Join(outDir, "download.part")
+		os.WriteFile(src, []byte("same bytes"), 0644)
+		p, err := StoreFile(src, outDir, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("run %d: source file left behind", i)
+		}
+		paths = append(paths, p)
+	}
+	if paths[0] != paths[1] || !strings.HasPrefix(filepath.Base(paths[0]), "cat_") {
+		t.Errorf("reruns stored %q and %q, want one cat_<hash>.jpg", paths[0], paths[1])
+	}
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// Origins of captured media.
+const (
+	OriginNetwork = "network" // a response seen on the network
+	OriginCanvas  = "canvas"  // exported from an untainted <canvas>
+	OriginBlob    = "blob"    // a blob: object URL resolved in the page
+)
+
+// CapturedMedia is media the browser produced while rendering a page: an image, video or audio
+// response, a canvas export, or the bytes behind a blob: URL.
+type CapturedMedia struct {
+	URL      string
+	Origin   string
+	MIMEType string
+	Status   int64
+	Body     []byte // for network media, nil unless bodies were requested and the browser still had them
+
+	requestID network.RequestID
+	finished  bool
+}
+
+// mediaCapture collects media responses from CDP network events.
+type mediaCapture struct {
+	mu    sync.Mutex
+	order []network.RequestID
+	media map[network.RequestID]*CapturedMedia
+}
+
+func newMediaCapture() *mediaCapture {
+	return &mediaCapture{media: make(map[network.RequestID]*CapturedMedia)}
+}
+
+// isMediaMIME reports whether a MIME type is an image, video or audio type.
+func isMediaMIME(mime string) bool {
+	mime = strings.ToLower(mime)
+	return strings.HasPrefix(mime, "image/") || strings.HasPrefix(mime, "video/") || strings.HasPrefix(mime, "audio/")
+}
+
+// onEvent is registered with chromedp.ListenTarget; it must not block.
+func (c *mediaCapture) onEvent(ev any) {
+	switch ev := ev.(type) {
+	case *network.EventResponseReceived:
+		if ev.Response == nil || !isMediaMIME(ev.Response.MimeType) || strings.HasPrefix(ev.Response.URL, "data:") {
+			return
+		}
+		c.mu.Lock()
+		if _, ok := c.media[ev.RequestID]; !ok {
+			c.order = append(c.order, ev.RequestID)
+		}
+		c.media[ev.RequestID] = &CapturedMedia{
+			URL:       ev.Response.URL,
+			Origin:    OriginNetwork,
+			MIMEType:  ev.Response.MimeType,
+			Status:    ev.Response.Status,
+			requestID: ev.RequestID,
+		}
+		c.mu.Unlock()
+	case *network.EventLoadingFinished:
+		c.mu.Lock()
+		if m, ok := c.media[ev.RequestID]; ok {
+			m.finished = true
+		}
+		c.mu.Unlock()
+	}
+}
+
+// fetchBodies pulls the body of every finished media response with Network.getResponseBody,
+// so the bytes come from the browser's own request rather than a second fetch.
+func (c *mediaCapture) fetchBodies(ctx context.Context) error {
+	c.mu.Lock()
+	var pending []*CapturedMedia
+	for _, id := range c.order {
+		if m := c.media[id]; m.finished && m.Body == nil {
+			pending = append(pending, m)
+		}
+	}
+	c.mu.Unlock()
+	for _, m := range pending {
+		body, err := network.GetResponseBody(m.requestID).Do(ctx)
+		if err != nil {
+			// The browser may already have evicted the body; the URL is still a candidate.
+			continue
+		}
+		c.mu.Lock()
+		m.Body = body
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// results returns the captured media in the order the responses arrived.
+func (c *mediaCapture) results() []CapturedMedia {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CapturedMedia, 0, len(c.order))
+	for _, id := range c.order {
+		out = append(out, *c.media[id])
+	}
+	return out
+}
+
+// CapturedMediaURLs returns the URLs of captured media that have no body and still need downloading.
+func CapturedMediaURLs(media []CapturedMedia) []string {
+	var urls []string
+	for _, m := range media {
+		if m.Body == nil && m.Status >= 200 && m.Status < 400 {
+			urls = append(urls, m.URL)
+		}
+	}
+	return urls
+}
+
+// SavedMedia is a captured media file written to disk.
+type SavedMedia struct {
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+	Origin string `json:"origin"`
+}
+
+// SaveCapturedMedia writes the bodies of captured media to outDir, numbering files from startIdx.
+// Bodies go through the same type detection and accept filter as downloads. It returns what was
+// written, and a report entry for every body, saved or rejected.
+func SaveCapturedMedia(media []CapturedMedia, outDir string, startIdx int) ([]SavedMedia, []DownloadResult, error) {
+	var (
+		saved   []SavedMedia
+		results []DownloadResult
+	)
+	defer flushContentIndex()
+	idx := startIdx
+	for _, m := range media {
+		if len(m.Body) == 0 {
+			continue
+		}
+		r := DownloadResult{URL: m.URL, Status: DownloadOK, Attempts: 1, Method: MethodBrowser}
+		ins, err := inspectBytes(m.URL, m.Body[:min(len(m.Body), inspectLen)], m.MIMEType, int64(len(m.Body)))
+		if err != nil {
+			// Not media after all, or turned down by the accept filter
+			r.Status, r.ErrorType, r.Error = DownloadFailed, errorCategory(err), err.Error()
+			if rej, ok := rejectionFor(m.URL, err); ok {
+				r.Status, r.Error = DownloadRejected, rej.Reason
+			}
+			results = append(results, r)
+			continue
+		}
+		reportMismatch(m.URL, ins.Type)
+		fpath, err := saveBytes(outDir, NameVars{URL: m.URL, Idx: idx, Ext: ins.Type.Ext}, m.Body)
+		if err != nil {
+			return saved, results, fmt.Errorf("save captured media %s: %w", m.URL, err)
+		}
+		saved = append(saved, SavedMedia{Path: fpath, URL: m.URL, Origin: m.Origin})
+		r.File, r.Bytes = relFile(outDir, fpath), int64(len(m.Body))
+		results = append(results, r)
+		idx++
+	}
+	return saved, results, nil
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// pageMediaJS exports untainted canvases as PNG and reads the bytes behind blob: URLs used
+// by media elements. Neither can be fetched by the HTTP downloaders. A tainted canvas throws
+// a SecurityError from toDataURL and is skipped.
+const pageMediaJS = `(async () => {
+	const out = [];
+	const toBase64 = (bytes) => {
+		let bin = '';
+		for (let i = 0; i < bytes.length; i += 0x8000) {
+			bin += String.fromCharCode.apply(null, bytes.subarray(i, i + 0x8000));
+		}
+		return btoa(bin);
+	};
+	document.querySelectorAll('canvas').forEach((c, i) => {
+		if (!c.width || !c.height) return;
+		try {
+			const url = c.toDataURL('image/png');
+			out.push({origin: 'canvas', url: 'canvas:' + (c.id || i), type: 'image/png', data: url.slice(url.indexOf(',') + 1)});
+		} catch (e) {}
+	});
+	const blobs = new Set();
+	document.querySelectorAll('img, video, audio, source').forEach((el) => {
+		for (const u of [el.currentSrc, el.src, el.getAttribute('src')]) {
+			if (u && u.startsWith('blob:')) blobs.add(u);
+		}
+	});
+	for (const u of blobs) {
+		try {
+			const b = await (await fetch(u)).blob();
+			out.push({origin: 'blob', url: u, type: b.type, data: toBase64(new Uint8Array(await b.arrayBuffer()))});
+		} catch (e) {}
+	}
+	return out;
+})()`
+
+// pageMediaItem is one entry returned by pageMediaJS.
+type pageMediaItem struct {
+	Origin string `json:"origin"`
+	URL    string `json:"url"`
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+}
+
+// collectPageMedia runs pageMediaJS and appends the results to res.Media.
+func collectPageMedia(ctx context.Context, res *RenderResult) error {
+	var items []pageMediaItem
+	err := chromedp.Evaluate(pageMediaJS, &items, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	}).Do(ctx)
+	if err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("canvas/blob capture: %v", err))
+		return nil
+	}
+	for _, it := range items {
+		body, err := base64.StdEncoding.DecodeString(it.Data)
+		if err != nil || len(body) == 0 {
+			continue
+		}
+		res.Media = append(res.Media, CapturedMedia{
+			URL:      it.URL,
+			Origin:   it.Origin,
+			MIMEType: it.Type,
+			Status:   200,
+			Body:     body,
+		})
+	}
+	return nil
+}
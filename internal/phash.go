@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// nearDupDir is where lower-resolution near-duplicates are moved inside a job directory.
+const nearDupDir = "near-duplicates"
+
+// ImageHash is the perceptual (difference) hash of one image file.
+type ImageHash struct {
+	Path   string
+	Hash   uint64
+	Width  int
+	Height int
+	Bytes  int64
+}
+
+// NearDuplicate is one member of a near-duplicate cluster.
+type NearDuplicate struct {
+	Path     string `json:"path"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int64  `json:"bytes"`
+	Distance int    `json:"distance"` // Hamming distance to the kept image
+}
+
+// NearDuplicateCluster is a group of images that look the same; Keep is the highest resolution one.
+type NearDuplicateCluster struct {
+	Keep    NearDuplicate   `json:"keep"`
+	Dropped []NearDuplicate `json:"dropped"`
+}
+
+// HashImageFile decodes an image and computes its 64-bit dHash: the image is shrunk to 9x8
+// grey pixels and each bit says whether a pixel is brighter than its right neighbour, so
+// re-encodes and resizes of the same picture land within a few bits of each other.
+func HashImageFile(path string) (ImageHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageHash{}, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return ImageHash{}, fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return ImageHash{}, err
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return ImageHash{}, fmt.Errorf("decode %s: empty image", filepath.Base(path))
+	}
+	var grey [8][9]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			grey[y][x] = cellLuma(img, b, x, y, 9, 8)
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return ImageHash{Path: path, Hash: hash, Width: b.Dx(), Height: b.Dy(), Bytes: info.Size()}, nil
+}
+
+// cellLuma is the mean luma of cell (cx, cy) when b is divided into cols x rows cells.
+// Large cells are sampled on a grid rather than read pixel by pixel.
+func cellLuma(img image.Image, b image.Rectangle, cx, cy, cols, rows int) float64 {
+	x0 := b.Min.X + cx*b.Dx()/cols
+	x1 := b.Min.X + (cx+1)*b.Dx()/cols
+	y0 := b.Min.Y + cy*b.Dy()/rows
+	y1 := b.Min.Y + (cy+1)*b.Dy()/rows
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	stepX, stepY := max(1, (x1-x0)/16), max(1, (y1-y0)/16)
+	var sum float64
+	var n int
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// ClusterNearDuplicates groups images whose hashes differ in at most maxDistance bits from the
+// group's kept image, the one with the highest resolution (then the most bytes). Comparing with
+// that representative rather than any member keeps chains of small differences from pulling
+// unlike images together. Only groups with more than one member are returned.
+func ClusterNearDuplicates(hashes []ImageHash, maxDistance int) []NearDuplicateCluster {
+	order := append([]ImageHash(nil), hashes...)
+	sort.SliceStable(order, func(a, b int) bool {
+		pa, pb := order[a].Width*order[a].Height, order[b].Width*order[b].Height
+		if pa != pb {
+			return pa > pb
+		}
+		return order[a].Bytes > order[b].Bytes
+	})
+	taken := make([]bool, len(order))
+	var clusters []NearDuplicateCluster
+	for i, keep := range order {
+		if taken[i] {
+			continue
+		}
+		taken[i] = true
+		c := NearDuplicateCluster{Keep: nearDuplicate(keep, keep)}
+		for j := i + 1; j < len(order); j++ {
+			if !taken[j] && bits.OnesCount64(order[j].Hash^keep.Hash) <= maxDistance {
+				taken[j] = true
+				c.Dropped = append(c.Dropped, nearDuplicate(order[j], keep))
+			}
+		}
+		if len(c.Dropped) > 0 {
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters
+}
+
+func nearDuplicate(h, keep ImageHash) NearDuplicate {
+	return NearDuplicate{
+		Path:     h.Path,
+		Width:    h.Width,
+		Height:   h.Height,
+		Bytes:    h.Bytes,
+		Distance: bits.OnesCount64(h.Hash ^ keep.Hash),
+	}
+}
+
+// SetAsideNearDuplicates moves the dropped members of each cluster into dir/near-duplicates,
+// so only the best copy stays next to the other downloads, and rewrites their paths.
+func SetAsideNearDuplicates(dir string, clusters []NearDuplicateCluster) error {
+	if len(clusters) == 0 {
+		return nil
+	}
+	defer flushContentIndex()
+	aside := filepath.Join(dir, nearDupDir)
+	for ci := range clusters {
+		for di := range clusters[ci].Dropped {
+			d := &clusters[ci].Dropped[di]
+			rel, err := filepath.Rel(dir, d.Path)
+			if err != nil || !filepath.IsLocal(rel) {
+				rel = filepath.Base(d.Path)
+			}
+			dest := filepath.Join(aside, rel)
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(d.Path, dest); err != nil {
+				return fmt.Errorf("set aside %s: %w", filepath.Base(d.Path), err)
+			}
+			if ix := currentContentIndex(); ix != nil {
+				if err := ix.relocate(d.Path, dest); err != nil {
+					return err
+				}
+			}
+			d.Path = dest
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClusterNearDuplicates(t *testing.T) {
+	tests := []struct {
+		name   string
+		hashes []ImageHash
+		max    int
+		want   map[string][]string // kept path -> dropped paths
+	}{
+		{
+			name: "highest resolution is kept",
+			hashes: []ImageHash{
+				{Path: "small", Hash: 0x0F, Width: 100, Height: 100},
+				{Path: "big", Hash: 0x0E, Width: 400, Height: 300},
+				{Path: "mid", Hash: 0x0F, Width: 200, Height: 150},
+			},
+			max:  2,
+			want: map[string][]string{"big": {"mid", "small"}},
+		},
+		{
+			name: "bytes break a resolution tie",
+			hashes: []ImageHash{
+				{Path: "light", Hash: 1, Width: 100, Height: 100, Bytes: 1000},
+				{Path: "heavy", Hash: 1, Width: 100, Height: 100, Bytes: 5000},
+			},
+			max:  0,
+			want: map[string][]string{"heavy": {"light"}},
+		},
+		{
+			// b is within reach of both, c only of b: a chain must not join a and c.
+			name: "no chaining through a middle image",
+			hashes: []ImageHash{
+				{Path: "a", Hash: 0, Width: 300, Height: 300},
+				{Path: "b", Hash: 0x07, Width: 200, Height: 200},
+				{Path: "c", Hash: 0x3F, Width: 100, Height: 100},
+			},
+			max:  4,
+			want: map[string][]string{"a": {"b"}},
+		},
+		{
+			name: "nothing close",
+			hashes: []ImageHash{
+				{Path: "a", Hash: 0, Width: 10, Height: 10},
+				{Path: "b", Hash: ^uint64(0), Width: 10, Height: 10},
+			},
+			max:  6,
+			want: map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		got := ClusterNearDuplicates(tt.hashes, tt.max)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d clusters, want %d: %+v", tt.name, len(got), len(tt.want), got)
+			continue
+		}
+		for _, c := range got {
+			want, ok := tt.want[c.Keep.Path]
+			if !ok || len(c.Dropped) != len(want) {
+				t.Errorf("%s: cluster %+v, want keep among %v", tt.name, c, tt.want)
+				continue
+			}
+			for i, d := range c.Dropped {
+				if d.Path != want[i] || d.Distance > tt.max {
+					t.Errorf("%s: dropped %d = %+v, want %s within %d", tt.name, i, d, want[i], tt.max)
+				}
+			}
+		}
+	}
+}
+
+// writeGradient saves a w x h PNG that gets brighter left to right, or right to left.
+func writeGradient(t *testing.T, path string, w, h int, reverse bool) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := x * 255 / w
+			if reverse {
+				v = 255 - v
+			}
+			v = (v + y*40/h) % 256
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashImageFile(t *testing.T) {
+	dir := t.TempDir()
+	big, small, other := filepath.Join(dir, "big.png"), filepath.Join(dir, "small.png"), filepath.Join(dir, "other.png")
+	writeGradient(t, big, 640, 480, false)
+	writeGradient(t, small, 160, 120, false)
+	writeGradient(t, other, 640, 480, true)
+	hb, err := HashImageFile(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, _ := HashImageFile(small)
+	ho, _ := HashImageFile(other)
+	if hb.Width != 640 || hb.Height != 480 || hb.Bytes == 0 {
+		t.Errorf("hash of big.png: %+v", hb)
+	}
+	if d := bits.OnesCount64(hb.Hash ^ hs.Hash); d > 6 {
+		t.Errorf("resized copy is %d bits away, want at most 6", d)
+	}
+	if d := bits.OnesCount64(hb.Hash ^ ho.Hash); d <= 6 {
+		t.Errorf("mirrored image is only %d bits away", d)
+	}
+	os.WriteFile(filepath.Join(dir, "bad.png"), []byte("not a png"), 0644)
+	if _, err := HashImageFile(filepath.Join(dir, "bad.png")); err == nil {
+		t.Error("undecodable file: got no error")
+	}
+}
+
+func TestSetAsideNearDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	keep, drop := filepath.Join(dir, "keep.png"), filepath.Join(dir, "sub", "drop.png")
+	os.WriteFile(keep, []byte("k"), 0644)
+	os.WriteFile(drop, []byte("d"), 0644)
+	clusters := []NearDuplicateCluster{{Keep: NearDuplicate{Path: keep}, Dropped: []NearDuplicate{{Path: drop}}}}
+	if err := SetAsideNearDuplicates(dir, clusters); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, nearDupDir, "sub", "drop.png")
+	if clusters[0].Dropped[0].Path != moved {
+		t.Errorf("dropped path = %q, want %q", clusters[0].Dropped[0].Path, moved)
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("set-aside file: %v", err)
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("kept file: %v", err)
+	}
+}
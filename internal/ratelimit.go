@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHostRate is the steady request rate per host, in requests per second.
+	DefaultHostRate = 1 / 1.2
+	// minHostRate is how far repeated throttling can slow a host down.
+	minHostRate = 1.0 / 60
+	// recoverEvery is how long a host must go without being throttled before each step back up.
+	recoverEvery = 30 * time.Second
+	// maxRetryAfter caps how long a server can ask us to stay away.
+	maxRetryAfter = 10 * time.Minute
+)
+
+// HostLimitState is a snapshot of one host's limiter.
+type HostLimitState struct {
+	Host         string    `json:"host"`
+	Rate         float64   `json:"rate"`      // current requests per second
+	BaseRate     float64   `json:"base_rate"` // rate it recovers to
+	Tokens       float64   `json:"tokens"`
+	BlockedUntil time.Time `json:"blocked_until,omitempty"`
+	Throttled    int       `json:"throttled"` // 429/503 responses seen
+	LastThrottle time.Time `json:"last_throttle,omitempty"`
+}
+
+// hostBucket is a token bucket whose rate drops when the host pushes back.
+type hostBucket struct {
+	rate         float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	throttled    int
+	lastThrottle time.Time
+	lastRecover  time.Time
+}
+
+// RateLimiter paces requests per host across every job in the process.
+type RateLimiter struct {
+	mu       sync.Mutex
+	baseRate float64
+	burst    float64
+	hosts    map[string]*hostBucket
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second per host, in bursts of up to burst.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{baseRate: rate, burst: float64(burst), hosts: make(map[string]*hostBucket)}
+}
+
+// hostLimiter is shared by every download path.
+var hostLimiter = NewRateLimiter(DefaultHostRate, 1)
+
+// SetHostRate changes the per-host base rate and burst of the shared limiter.
+func SetHostRate(rate float64, burst int) {
+	hostLimiter.mu.Lock()
+	defer hostLimiter.mu.Unlock()
+	hostLimiter.baseRate = rate
+	hostLimiter.burst = float64(max(burst, 1))
+	for _, b := range hostLimiter.hosts {
+		b.rate = min(b.rate, rate)
+	}
+}
+
+// RateLimitState returns the shared limiter's view of every host it has seen.
+func RateLimitState() []HostLimitState { return hostLimiter.State() }
+
+func (l *RateLimiter) bucket(host string, now time.Time) *hostBucket {
+	b, ok := l.hosts[host]
+	if !ok {
+		b = &hostBucket{rate: l.baseRate, tokens: l.burst, last: now}
+		l.hosts[host] = b
+	}
+	return b
+}
+
+// refill adds the tokens earned since the last look and lets the rate creep back up after
+// a quiet period.
+func (l *RateLimiter) refill(b *hostBucket, now time.Time) {
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.rate < l.baseRate && now.Sub(b.lastThrottle) >= recoverEvery && now.Sub(b.lastRecover) >= recoverEvery {
+		b.rate = min(l.baseRate, b.rate*1.5)
+		b.lastRecover = now
+	}
+}
+
+// Wait blocks until a request to host is allowed.
+func (l *RateLimiter) Wait(host string) {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		b := l.bucket(host, now)
+		l.refill(b, now)
+		var wait time.Duration
+		switch {
+		case now.Before(b.blockedUntil):
+			wait = b.blockedUntil.Sub(now)
+		case b.tokens >= 1:
+			b.tokens--
+			l.mu.Unlock()
+			return
+		default:
+			wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// Observe adjusts host's pace from a response: 429 and 503 halve the rate and honor Retry-After.
+func (l *RateLimiter) Observe(host string, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(host, now)
+	l.refill(b, now)
+	b.rate = max(minHostRate, b.rate/2)
+	b.tokens = 0
+	b.throttled++
+	b.lastThrottle = now
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		if until := now.Add(min(d, maxRetryAfter)); until.After(b.blockedUntil) {
+			b.blockedUntil = until
+		}
+	}
+}
+
+// State returns a snapshot of every host, sorted by name.
+func (l *RateLimiter) State() []HostLimitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	out := make([]HostLimitState, 0, len(l.hosts))
+	for host, b := range l.hosts {
+		l.refill(b, now)
+		s := HostLimitState{
+			Host: host, Rate: b.rate, BaseRate: l.baseRate, Tokens: b.tokens,
+			Throttled: b.throttled, LastThrottle: b.lastThrottle,
+		}
+		if now.Before(b.blockedUntil) {
+			s.BlockedUntil = b.blockedUntil
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// parseRetryAfter reads a Retry-After value given either in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(sec, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
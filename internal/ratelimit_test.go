@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-3", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		wantRate   float64
+		wantBlock  bool
+	}{
+		{http.StatusOK, "", 2, false},
+		{http.StatusNotFound, "", 2, false},
+		{http.StatusTooManyRequests, "", 1, false},
+		{http.StatusServiceUnavailable, "30", 1, true},
+		{http.StatusTooManyRequests, "99999", 1, true}, // capped at maxRetryAfter
+	}
+	for _, tt := range tests {
+		l := NewRateLimiter(2, 1)
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		l.Observe("x", resp)
+		var s HostLimitState
+		for _, h := range l.State() {
+			if h.Host == "x" {
+				s = h
+			}
+		}
+		if tt.status != http.StatusTooManyRequests && tt.status != http.StatusServiceUnavailable {
+			if s.Host != "" {
+				t.Errorf("%d: host tracked without pushback: %+v", tt.status, s)
+			}
+			continue
+		}
+		if s.Rate != tt.wantRate || s.Throttled != 1 {
+			t.Errorf("%d: rate %v, throttled %d; want %v, 1", tt.status, s.Rate, s.Throttled, tt.wantRate)
+		}
+		if blocked := !s.BlockedUntil.IsZero(); blocked != tt.wantBlock {
+			t.Errorf("%d Retry-After %q: blocked = %v, want %v", tt.status, tt.retryAfter, blocked, tt.wantBlock)
+		}
+		if time.Until(s.BlockedUntil) > maxRetryAfter {
+			t.Errorf("blocked for %v, over the cap", time.Until(s.BlockedUntil))
+		}
+	}
+}
+
+func TestChunkedRangesArePaced(t *testing.T) {
+	const rate = 20 // requests per second
+	SetHostRate(rate, 1)
+	t.Cleanup(func() { SetHostRate(DefaultHostRate, 1) })
+	body := testBody(4000)
+	var (
+		mu     sync.Mutex
+		ranges []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, time.Now())
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL+"/big.bin", nil)
+	d, err := beginResumable(srv.Client(), req, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.resp.Body.Close()
+	if err := d.downloadChunked(srv.Client(), req, int64(len(body))); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(d.part)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("chunked part has %d bytes, want the %d-byte body", len(got), len(body))
+	}
+	if len(ranges) != chunkCount {
+		t.Fatalf("server saw %d range requests, want %d", len(ranges), chunkCount)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Before(ranges[j]) })
+	// Each range takes a token of its own, so no two arrive closer than the rate allows
+	// (with some slack for timer jitter).
+	for i := 1; i < len(ranges); i++ {
+		if gap := ranges[i].Sub(ranges[i-1]); gap < time.Second/rate/2 {
+			t.Errorf("range %d arrived %v after the previous one", i, gap)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Report file names inside a job directory.
+const (
+	ReportJSON = "report.json"
+	ReportCSV  = "report.csv"
+)
+
+// Outcomes of one download.
+const (
+	DownloadOK        = "ok"        // downloaded and saved
+	DownloadUnchanged = "unchanged" // the server answered 304; the copy from an earlier run is current
+	DownloadSkipped   = "skipped"   // finished by an earlier attempt of this job
+	DownloadRejected  = "rejected"  // turned down by the accept filter or not media
+	DownloadFailed    = "failed"
+)
+
+// DownloadResult is what happened to one URL of a batch.
+type DownloadResult struct {
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	HTTPCode   int    `json:"http_code,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Attempts   int    `json:"attempts"`
+	Method     string `json:"method"`
+	File       string `json:"file,omitempty"` // relative to the job directory; ../<job>/... for another job's copy
+	ErrorType  string `json:"error_type,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of a download batch, one entry per URL in input order.
+type BatchResult struct {
+	Results    []DownloadResult `json:"results"`
+	Succeeded  int              `json:"succeeded"` // ok, unchanged and skipped
+	Rejected   int              `json:"rejected"`
+	Failed     int              `json:"failed"`
+	Bytes      int64            `json:"bytes"`
+	DurationMS int64            `json:"duration_ms"`
+	// Connections counts new and reused connections process-wide while the batch ran.
+	Connections ConnStats `json:"connections"`
+}
+
+// Merge adds results obtained outside the batch, such as media saved straight from the
+// browser, and updates the totals.
+func (b *BatchResult) Merge(results []DownloadResult) {
+	b.Results = append(b.Results, results...)
+	b.tally(time.Duration(b.DurationMS) * time.Millisecond)
+}
+
+// tally fills in the totals from Results.
+func (b *BatchResult) tally(elapsed time.Duration) {
+	b.Succeeded, b.Rejected, b.Failed, b.Bytes = 0, 0, 0, 0
+	for _, r := range b.Results {
+		switch r.Status {
+		case DownloadRejected:
+			b.Rejected++
+		case DownloadFailed:
+			b.Failed++
+		default:
+			b.Succeeded++
+		}
+		b.Bytes += r.Bytes
+	}
+	b.DurationMS = elapsed.Milliseconds()
+}
+
+// Rejections lists the downloads that were turned down, with the reason for each.
+func (b *BatchResult) Rejections() []Rejection {
+	var out []Rejection
+	for _, r := range b.Results {
+		if r.Status == DownloadRejected {
+			out = append(out, Rejection{URL: r.URL, Reason: r.Error})
+		}
+	}
+	return out
+}
+
+// JSON encodes the report.
+func (b *BatchResult) JSON() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// CSV encodes the per-URL results, one row each.
+func (b *BatchResult) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"url", "status", "http_code", "bytes", "duration_ms", "attempts", "method", "file", "error_type", "error"})
+	for _, r := range b.Results {
+		w.Write([]string{
+			r.URL, r.Status, strconv.Itoa(r.HTTPCode), strconv.FormatInt(r.Bytes, 10),
+			strconv.FormatInt(r.DurationMS, 10), strconv.Itoa(r.Attempts), r.Method, r.File, r.ErrorType, r.Error,
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
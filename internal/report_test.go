@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBatchResultTally(t *testing.T) {
+	b := &BatchResult{Results: []DownloadResult{
+		{URL: "a", Status: DownloadOK, Bytes: 100},
+		{URL: "b", Status: DownloadUnchanged, Bytes: 50},
+		{URL: "c", Status: DownloadSkipped, Bytes: 25},
+		{URL: "d", Status: DownloadRejected, Error: "too small"},
+		{URL: "e", Status: DownloadFailed, ErrorType: "Timeout", Error: "timeout"},
+	}}
+	b.tally(1500 * time.Millisecond)
+	if b.Succeeded != 3 || b.Rejected != 1 || b.Failed != 1 || b.Bytes != 175 || b.DurationMS != 1500 {
+		t.Errorf("tally = %+v", b)
+	}
+	if r := b.Rejections(); len(r) != 1 || r[0].URL != "d" || r[0].Reason != "too small" {
+		t.Errorf("Rejections() = %+v", r)
+	}
+	b.Merge([]DownloadResult{{URL: "f", Status: DownloadOK, Bytes: 5, Method: MethodBrowser}})
+	if len(b.Results) != 6 || b.Succeeded != 4 || b.Bytes != 180 || b.DurationMS != 1500 {
+		t.Errorf("after Merge: %+v", b)
+	}
+}
+
+func TestBatchResultEncoding(t *testing.T) {
+	b := &BatchResult{Results: []DownloadResult{
+		{URL: "http://x/a.jpg", Status: DownloadOK, HTTPCode: 200, Bytes: 1234, DurationMS: 56, Attempts: 1, Method: MethodCookies, File: "a.jpg"},
+		{URL: "http://x/b,\"c\".jpg", Status: DownloadFailed, HTTPCode: 404, Attempts: 3, Method: MethodBasic, ErrorType: "HTTP 404", Error: "bad status: 404 Not Found"},
+	}}
+	b.tally(time.Second)
+
+	data, err := b.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back BatchResult
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Results) != 2 || back.Results[1] != b.Results[1] || back.Failed != 1 {
+		t.Errorf("JSON round trip = %+v", back)
+	}
+
+	data, err = b.CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "url" || len(rows[0]) != 10 {
+		t.Fatalf("CSV rows = %q", rows)
+	}
+	want := []string{"http://x/b,\"c\".jpg", "failed", "404", "0", "0", "3", "basic", "", "HTTP 404", "bad status: 404 Not Found"}
+	for i, v := range want {
+		if rows[2][i] != v {
+			t.Errorf("CSV %s = %q, want %q", rows[0][i], rows[2][i], v)
+		}
+	}
+}
+
+func TestDownloadBatchReport(t *testing.T) {
+	fastHosts(t)
+	withAcceptFilter(t, AcceptFilter{})
+	img := testPNG(t, 40, 30)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Write([]byte("<html></html>"))
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(img)
+		case "/soft404.png":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<!DOCTYPE html><html><body>Not found</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	urls := []string{srv.URL + "/ok.png", srv.URL + "/soft404.png", srv.URL + "/gone.png"}
+	batch := DownloadImagesAdvancedBatch(urls, srv.URL+"/page", t.TempDir())
+	want := []struct {
+		status   string
+		code     int
+		attempts int
+	}{
+		{DownloadOK, 200, 1},
+		{DownloadRejected, 200, 1},
+		{DownloadFailed, 404, 3},
+	}
+	if len(batch.Results) != len(want) {
+		t.Fatalf("%d results for %d URLs", len(batch.Results), len(urls))
+	}
+	for i, w := range want {
+		r := batch.Results[i]
+		if r.URL != urls[i] || r.Status != w.status || r.HTTPCode != w.code || r.Attempts != w.attempts {
+			t.Errorf("result %d = %+v, want %s, HTTP %d, %d attempts", i, r, w.status, w.code, w.attempts)
+		}
+	}
+	if r := batch.Results[0]; r.File == "" || r.Bytes != int64(len(img)) {
+		t.Errorf("ok result does not name its file: %+v", r)
+	}
+	if batch.Results[2].ErrorType != "HTTP 404" {
+		t.Errorf("failed result error type = %q", batch.Results[2].ErrorType)
+	}
+	if batch.Succeeded != 1 || batch.Rejected != 1 || batch.Failed != 1 {
+		t.Errorf("totals = %d ok, %d rejected, %d failed", batch.Succeeded, batch.Rejected, batch.Failed)
+	}
+}
+
+func TestRelFile(t *testing.T) {
+	out := filepath.Join("Downloaded", "job_b")
+	tests := map[string]string{
+		filepath.Join(out, "a.jpg"):                   "a.jpg",
+		filepath.Join(out, "sub", "a.jpg"):            filepath.Join("sub", "a.jpg"),
+		filepath.Join("Downloaded", "job_a", "a.jpg"): filepath.Join("..", "job_a", "a.jpg"),
+		filepath.Join(out, nearDupDir, "..", "b.png"): "b.png",
+	}
+	for in, want := range tests {
+		if got := relFile(out, in); got != want {
+			t.Errorf("relFile(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partDir is the hidden directory inside an output directory that holds in-progress
+// downloads (<key>.part) and their state (<key>.json).
+const partDir = ".downloads"
+
+// errAlreadyDownloaded is returned by beginResumable when an earlier run finished the URL.
+var errAlreadyDownloaded = errors.New("already downloaded")
+
+// partState is what is remembered about a download between attempts and process restarts.
+type partState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"` // total length, -1 if the server did not say
+	Done         bool   `json:"done,omitempty"`
+	File         string `json:"file,omitempty"` // final file name once done
+}
+
+// resumable is one attempt at a download that continues a .part file when it can.
+type resumable struct {
+	part   string
+	meta   string
+	state  partState
+	offset int64 // bytes of the part file kept for this attempt
+	resp   *http.Response
+	hash   hash.Hash // SHA-256 of the part file, fed while streaming; nil if not known
+}
+
+// partKey names the part and state files for a URL.
+func partKey(rawurl string) string {
+	sum := sha256.Sum256([]byte(rawurl))
+	return hex.EncodeToString(sum[:12])
+}
+
+// beginResumable sends req, asking only for the missing bytes when a .part file from an
+// earlier attempt exists and the server can prove (If-Range) the file has not changed.
+// The caller must close resp.Body.
+func beginResumable(client *http.Client, req *http.Request, outDir string) (*resumable, error) {
+	dir := filepath.Join(outDir, partDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	key := partKey(req.URL.String())
+	d := &resumable{
+		part:  filepath.Join(dir, key+".part"),
+		meta:  filepath.Join(dir, key+".json"),
+		state: partState{URL: req.URL.String(), Size: -1},
+	}
+	if data, err := os.ReadFile(d.meta); err == nil {
+		var st partState
+		if json.Unmarshal(data, &st) == nil && st.URL == d.state.URL {
+			d.state = st
+		}
+	}
+	if d.state.Done {
+		if _, err := os.Stat(filepath.Join(outDir, d.state.File)); err == nil {
+			return d, errAlreadyDownloaded
+		}
+		d.state = partState{URL: d.state.URL, Size: -1}
+	}
+	if info, err := os.Stat(d.part); err == nil {
+		d.offset = info.Size()
+	}
+	validator := d.state.LastModified
+	if d.state.ETag != "" && !strings.HasPrefix(d.state.ETag, "W/") {
+		validator = d.state.ETag // If-Range needs a strong validator
+	}
+	if d.offset > 0 && validator != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+		req.Header.Set("If-Range", validator)
+	} else {
+		d.offset = 0
+	}
+	cache := currentHTTPCache()
+	revalidating := false
+	if cache != nil && d.offset == 0 {
+		req = req.Clone(req.Context())
+		revalidating = cache.conditional(req)
+	}
+	hostLimiter.Wait(req.URL.Host)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, classifyError(req.URL.String(), err)
+	}
+	hostLimiter.Observe(req.URL.Host, resp)
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		// Unchanged since an earlier run, which already has it.
+		resp.Body.Close()
+		if e, ok := cache.hit(d.state.URL); ok {
+			d.state.File = e.File
+		}
+		return d, errNotModified
+	}
+	d.resp = resp
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.offset {
+			resp.Body.Close()
+			d.discard()
+			return nil, fmt.Errorf("server resumed at the wrong offset (%q)", resp.Header.Get("Content-Range"))
+		}
+		d.state.Size = total
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The part file already holds everything; the 416 body is an error page, not content.
+		if d.state.Size == d.offset {
+			resp.Body.Close()
+			resp.StatusCode, resp.Body, resp.ContentLength = http.StatusOK, http.NoBody, 0
+		} else {
+			resp.Body.Close()
+			d.discard()
+			return nil, fmt.Errorf("server refused to resume: %s", resp.Status)
+		}
+	default:
+		d.offset = 0
+		d.state.Size = resp.ContentLength
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if et := resp.Header.Get("ETag"); et != "" {
+			d.state.ETag = et
+		}
+		if lm := resp.Header.Get("Last-Modified"); lm != "" {
+			d.state.LastModified = lm
+		}
+		if err := d.saveState(); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// parseContentRange parses "bytes start-end/total"; total is -1 when given as "*".
+func parseContentRange(v string) (start, total int64, ok bool) {
+	v = strings.TrimSpace(strings.TrimPrefix(v, "bytes "))
+	rng, size, found := strings.Cut(v, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// write appends the response body to the part file. On error the bytes received so far
+// stay on disk for the next attempt.
+func (d *resumable) write(body io.Reader) (int64, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if d.offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	d.hash = sha256.New()
+	if d.offset > 0 {
+		// Bytes kept from an earlier attempt are part of the content too.
+		prev, err := os.Open(d.part)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.CopyN(d.hash, prev, d.offset)
+		prev.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	f, err := os.OpenFile(d.part, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(io.MultiWriter(f, d.hash), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// A connection that stalls or drops mid-body is a transport error like any other.
+		return n, classifyError(d.state.URL, err)
+	}
+	if total := d.offset + n; d.state.Size >= 0 && total != d.state.Size {
+		return n, fmt.Errorf("incomplete download: got %d of %d bytes", total, d.state.Size)
+	}
+	return n, nil
+}
+
+// size is the number of bytes in the part file.
+func (d *resumable) size() int64 {
+	info, err := os.Stat(d.part)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// finish names the completed part file and moves it under outDir, or points at an identical
+// file already on disk, and remembers that the URL is done. It returns the file now holding
+// the content.
+func (d *resumable) finish(outDir string, v NameVars) (string, error) {
+	v.URL = d.state.URL
+	if d.hash != nil {
+		v.SHA256 = hex.EncodeToString(d.hash.Sum(nil))
+	} else {
+		sum, err := hashFile(d.part)
+		if err != nil {
+			return "", err
+		}
+		v.SHA256 = sum
+	}
+	size := d.size()
+	dest, err := StoreFile(d.part, outDir, v)
+	if err != nil {
+		return "", err
+	}
+	if cache := currentHTTPCache(); cache != nil {
+		if err := cache.storeDownload(v.URL, d.state.ETag, d.state.LastModified, v.SHA256, dest, size); err != nil {
+			return "", err
+		}
+	}
+	name := dest
+	if rel, err := filepath.Rel(outDir, dest); err == nil {
+		name = rel
+	}
+	d.state.Done, d.state.File = true, name
+	return dest, d.saveState()
+}
+
+// discard drops the part file and its state, so the next attempt starts over.
+func (d *resumable) discard() {
+	os.Remove(d.part)
+	os.Remove(d.meta)
+}
+
+func (d *resumable) saveState() error {
+	data, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.meta, data, 0644)
+}
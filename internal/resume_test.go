@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastHosts lifts the shared per-host rate limit for the length of a test.
+func fastHosts(t *testing.T) {
+	t.Helper()
+	SetHostRate(1000, 100)
+	t.Cleanup(func() { SetHostRate(DefaultHostRate, 1) })
+}
+
+// testBody is n bytes of recognizable content.
+func testBody(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + i%26)
+	}
+	return b
+}
+
+// servePart writes a part file holding part and its state for rawurl under outDir.
+func servePart(t *testing.T, outDir, rawurl string, part []byte, st partState) {
+	t.Helper()
+	dir := filepath.Join(outDir, partDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	key := partKey(rawurl)
+	if err := os.WriteFile(filepath.Join(dir, key+".part"), part, 0644); err != nil {
+		t.Fatal(err)
+	}
+	st.URL = rawurl
+	data, _ := json.Marshal(st)
+	if err := os.WriteFile(filepath.Join(dir, key+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in           string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 0-99/100", 0, 100, true},
+		{"bytes 500-999/1000", 500, 1000, true},
+		{"bytes 10-19/*", 10, -1, true},
+		{"bytes 7-8/9 ", 7, 9, true},
+		{"bytes */100", 0, 0, false},
+		{"bytes 0-99", 0, 0, false},
+		{"bytes x-99/100", 0, 0, false},
+		{"bytes 0-99/abc", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.in)
+		if ok != tt.ok || (ok && (start != tt.start || total != tt.total)) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", tt.in, start, total, ok, tt.start, tt.total, tt.ok)
+		}
+	}
+}
+
+func TestBeginResumable(t *testing.T) {
+	fastHosts(t)
+	body := testBody(4000)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		part      []byte
+		state     partState
+		wantRange string // Range header the server should see
+		wantCode  int
+	}{
+		{"fresh", nil, partState{Size: -1}, "", http.StatusOK},
+		{"resume by etag", body[:1500], partState{ETag: `"v1"`, Size: int64(len(body))}, "bytes=1500-", http.StatusPartialContent},
+		{"resume by date", body[:1500], partState{LastModified: modTime.Format(http.TimeFormat), Size: int64(len(body))}, "bytes=1500-", http.StatusPartialContent},
+		{"weak etag is not a validator", body[:1500], partState{ETag: `W/"v1"`, Size: int64(len(body))}, "", http.StatusOK},
+		{"changed file", []byte(strings.Repeat("x", 1500)), partState{ETag: `"v0"`, Size: int64(len(body))}, "bytes=1500-", http.StatusOK},
+		{"complete part, 416", body, partState{ETag: `"v1"`, Size: int64(len(body))}, "bytes=4000-", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotRange string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRange = r.Header.Get("Range")
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "file.bin", modTime, bytes.NewReader(body))
+			}))
+			defer srv.Close()
+			outDir := t.TempDir()
+			rawurl := srv.URL + "/file.bin"
+			if tt.part != nil {
+				servePart(t, outDir, rawurl, tt.part, tt.state)
+			}
+			req, _ := http.NewRequest("GET", rawurl, nil)
+			d, err := beginResumable(srv.Client(), req, outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.resp.Body.Close()
+			if gotRange != tt.wantRange {
+				t.Errorf("Range = %q, want %q", gotRange, tt.wantRange)
+			}
+			if d.resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", d.resp.StatusCode, tt.wantCode)
+			}
+			if _, err := d.write(d.resp.Body); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(d.part)
+			if !bytes.Equal(got, body) {
+				t.Errorf("part file has %d bytes, want the %d-byte body", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestBeginResumableRefused(t *testing.T) {
+	fastHosts(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such range", http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer srv.Close()
+	outDir := t.TempDir()
+	rawurl := srv.URL + "/file.bin"
+	servePart(t, outDir, rawurl, testBody(100), partState{ETag: `"v1"`, Size: 4000})
+	req, _ := http.NewRequest("GET", rawurl, nil)
+	if _, err := beginResumable(srv.Client(), req, outDir); err == nil {
+		t.Fatal("416 for an incomplete part: got no error")
+	}
+	if _, err := os.Stat(filepath.Join(outDir, partDir, partKey(rawurl)+".part")); !os.IsNotExist(err) {
+		t.Errorf("part file kept after a refused resume (err = %v)", err)
+	}
+}
+
+func TestResumableWriteIncomplete(t *testing.T) {
+	fastHosts(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.Write(testBody(400))
+	}))
+	defer srv.Close()
+	outDir := t.TempDir()
+	req, _ := http.NewRequest("GET", srv.URL+"/file.bin", nil)
+	d, err := beginResumable(srv.Client(), req, outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.resp.Body.Close()
+	n, err := d.write(d.resp.Body)
+	if err == nil {
+		t.Fatal("short body: got no error")
+	}
+	if n != 400 || d.size() != 400 {
+		t.Errorf("wrote %d, part has %d bytes; want 400 kept for the next attempt", n, d.size())
+	}
+}
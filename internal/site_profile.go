@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads from JSON as a string such as "1.5s" or "300ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// SiteProfile holds per-site rendering settings, matched by host.
+type SiteProfile struct {
+	// Host matches the page host exactly or any of its subdomains.
+	Host  string `json:"host"`
+	Steps []Step `json:"steps,omitempty"`
+	// Waits replace the default "body is ready" check and run after Steps.
+	Waits []WaitStrategy `json:"waits,omitempty"`
+	// Emulation names presets (see EmulationPresets) or profiles from Devices to render as.
+	Emulation []string `json:"emulation,omitempty"`
+	// Devices defines custom emulation profiles for this site.
+	Devices []EmulationProfile `json:"devices,omitempty"`
+	// Intercept controls request blocking, extra headers and cookies while rendering.
+	Intercept *InterceptConfig `json:"intercept,omitempty"`
+}
+
+// LoadSiteProfiles reads a JSON array of site profiles. A missing file is not an error.
+func LoadSiteProfiles(path string) ([]SiteProfile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []SiteProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, p := range profiles {
+		for j, s := range p.Steps {
+			if err := s.validate(); err != nil {
+				return nil, fmt.Errorf("%s: profile %q step %d: %w", path, p.Host, j+1, err)
+			}
+		}
+		for j, w := range p.Waits {
+			if err := w.validate(); err != nil {
+				return nil, fmt.Errorf("%s: profile %q wait %d: %w", path, p.Host, j+1, err)
+			}
+		}
+		if _, err := p.EmulationProfiles(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		profiles[i].Host = strings.ToLower(p.Host)
+	}
+	return profiles, nil
+}
+
+// ProfileFor returns the most specific profile whose host matches pageURL, or nil.
+func ProfileFor(profiles []SiteProfile, pageURL string) *SiteProfile {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	var best *SiteProfile
+	for i := range profiles {
+		p := &profiles[i]
+		if host == p.Host || strings.HasSuffix(host, "."+p.Host) {
+			if best == nil || len(p.Host) > len(best.Host) {
+				best = p
+			}
+		}
+	}
+	return best
+}
+
+// EmulationProfiles resolves the profile's emulation names against its own devices and the presets.
+func (p *SiteProfile) EmulationProfiles() ([]EmulationProfile, error) {
+	var out []EmulationProfile
+	for _, name := range p.Emulation {
+		found := false
+		for _, d := range p.Devices {
+			if d.Name == name {
+				out = append(out, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			preset, ok := EmulationPresets[name]
+			if !ok {
+				return nil, fmt.Errorf("profile %q: unknown emulation %q", p.Host, name)
+			}
+			out = append(out, preset)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// sniffLen is how many leading bytes are inspected to identify a file.
+const sniffLen = 512
+
+// Where a file type was learned from, most trusted first.
+const (
+	TypeFromContent = "content" // magic bytes
+	TypeFromHeader  = "header"  // Content-Type
+	TypeFromURL     = "url"     // extension in the URL path
+)
+
+// FileType is what a download turned out to be.
+type FileType struct {
+	MIME   string
+	Ext    string
+	Source string
+	// Mismatch describes a disagreement between the bytes, the Content-Type and the URL, if any.
+	Mismatch string
+}
+
+// isoBrands maps ISO-BMFF major brands to MIME types. Go's sniffer only knows generic MP4.
+var isoBrands = map[string]string{
+	"avif": "image/avif", "avis": "image/avif",
+	"heic": "image/heic", "heix": "image/heic", "hevc": "image/heic", "hevx": "image/heic",
+	"mif1": "image/heif", "msf1": "image/heif",
+	"isom": "video/mp4", "iso2": "video/mp4", "iso5": "video/mp4", "iso6": "video/mp4",
+	"mp41": "video/mp4", "mp42": "video/mp4", "avc1": "video/mp4", "dash": "video/mp4",
+	"M4V ": "video/mp4", "M4A ": "audio/mp4",
+	"qt  ": "video/quicktime",
+}
+
+// sniffMIME identifies a file from its leading bytes; "" if it cannot tell.
+func sniffMIME(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if m, ok := isoBrands[string(head[8:12])]; ok {
+			return m
+		}
+	}
+	if len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+	if bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		// EBML; the DocType tells WebM from Matroska.
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+	trimmed := bytes.TrimSpace(head)
+	if bytes.HasPrefix(trimmed, []byte("<svg")) ||
+		(bytes.HasPrefix(trimmed, []byte("<?xml")) && bytes.Contains(head, []byte("<svg"))) {
+		return "image/svg+xml"
+	}
+	m := baseMIME(http.DetectContentType(head))
+	if m == "application/octet-stream" || m == "text/plain" {
+		return "" // text/plain only means "no binary bytes seen"
+	}
+	return m
+}
+
+// baseMIME lower-cases a MIME type and drops its parameters.
+func baseMIME(ctype string) string {
+	if semi := strings.Index(ctype, ";"); semi != -1 {
+		ctype = ctype[:semi]
+	}
+	return strings.ToLower(strings.TrimSpace(ctype))
+}
+
+// urlExt returns the extension of a URL's path, ignoring query strings; "" if it has none
+// that looks like one.
+func urlExt(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if len(ext) < 2 || len(ext) > 6 {
+		return ""
+	}
+	return ext
+}
+
+// extToContentType is the reverse of contentTypeToExt, plus common aliases.
+func extToContentType(ext string) string {
+	switch ext {
+	case ".jpeg", ".jpe":
+		return "image/jpeg"
+	case ".tif":
+		return "image/tiff"
+	case ".heif":
+		return "image/heif"
+	case ".m4v":
+		return "video/mp4"
+	}
+	for m, e := range contentTypeToExt {
+		if e == ext {
+			return m
+		}
+	}
+	return ""
+}
+
+// isMarkup reports whether a MIME type is a document rather than media.
+func isMarkup(mime string) bool {
+	return mime == "text/html" || mime == "application/json" || mime == "text/xml" || mime == "application/xml"
+}
+
+// detectType decides what a download is: by its bytes first, then the Content-Type, then the
+// URL. A page (HTML, JSON, ...) where media was expected, such as an error page served with
+// 200, is a *DecodeError.
+func detectType(head []byte, contentType, rawurl string) (FileType, error) {
+	sniffed := sniffMIME(head)
+	header := baseMIME(contentType)
+	ext := urlExt(rawurl)
+	fromURL := extToContentType(ext)
+
+	var ft FileType
+	switch {
+	case sniffed != "":
+		ft = FileType{MIME: sniffed, Source: TypeFromContent}
+	case header != "" && header != "application/octet-stream":
+		ft = FileType{MIME: header, Source: TypeFromHeader}
+	case fromURL != "":
+		ft = FileType{MIME: fromURL, Source: TypeFromURL}
+	default:
+		return FileType{Ext: ".bin"}, nil
+	}
+	if isMarkup(ft.MIME) {
+		return ft, &DecodeError{URL: rawurl, MIME: ft.MIME}
+	}
+	ft.Ext = contentTypeToExt[ft.MIME]
+	if ft.Ext == "" {
+		ft.Ext = ext
+	}
+	if ft.Ext == "" {
+		ft.Ext = ".bin"
+	}
+	var claims []string
+	if header != "" && header != ft.MIME && header != "application/octet-stream" && !strings.HasPrefix(header, "binary/") {
+		claims = append(claims, "Content-Type "+header)
+	}
+	if fromURL != "" && fromURL != ft.MIME {
+		claims = append(claims, "URL extension "+ext)
+	}
+	if len(claims) > 0 {
+		ft.Mismatch = fmt.Sprintf("content is %s but %s", ft.MIME, strings.Join(claims, " and "))
+	}
+	return ft, nil
+}
+
+// reportMismatch prints a type disagreement so it shows up next to the download log.
+func reportMismatch(rawurl string, ft FileType) {
+	if ft.Mismatch != "" {
+		fmt.Printf("Type mismatch for %s: %s (saved as %s)\n", rawurl, ft.Mismatch, ft.Ext)
+	}
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+// isoHead is the start of an ISO-BMFF file with the given major brand.
+func isoHead(brand string) []byte {
+	return append([]byte{0, 0, 0, 0x20, 'f', 't', 'y', 'p'}, brand...)
+}
+
+func TestSniffMIME(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F'}, "image/jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"avif", isoHead("avif"), "image/avif"},
+		{"heic", isoHead("heic"), "image/heic"},
+		{"mp4", isoHead("isom"), "video/mp4"},
+		{"quicktime", isoHead("qt  "), "video/quicktime"},
+		{"webm", append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x82, 0x84}, "webm"...), "video/webm"},
+		{"matroska", append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x82, 0x88}, "matroska"...), "video/x-matroska"},
+		{"svg", []byte(`  <svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml"},
+		{"svg with prolog", []byte(`<?xml version="1.0"?><svg></svg>`), "image/svg+xml"},
+		{"html", []byte("<!DOCTYPE html><html>"), "text/html"},
+		{"plain text", []byte("just some words"), ""},
+		{"unknown binary", []byte{0x00, 0x01, 0x02, 0x03}, ""},
+	}
+	for _, tt := range tests {
+		if got := sniffMIME(tt.head); got != tt.want {
+			t.Errorf("%s: sniffMIME = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name       string
+		head       []byte
+		ctype, url string
+		wantExt    string
+		wantSource string
+		mismatch   bool
+		page       bool
+	}{
+		{"bytes win over a wrong extension", png, "", "http://x/a.jpg", ".png", TypeFromContent, true, false},
+		{"bytes win over a wrong header", png, "image/jpeg", "http://x/a", ".png", TypeFromContent, true, false},
+		{"octet-stream is no claim", png, "application/octet-stream", "http://x/a.png", ".png", TypeFromContent, false, false},
+		{"header with parameters", []byte{0, 1, 2}, "Image/WebP; charset=binary", "http://x/a", ".webp", TypeFromHeader, false, false},
+		{"extension last", []byte{0, 1, 2}, "", "http://x/a.JPEG?w=10", ".jpg", TypeFromURL, false, false},
+		{"nothing known", []byte{0, 1, 2}, "", "http://x/a", ".bin", "", false, false},
+		{"error page", []byte("<html><body>404</body></html>"), "text/html", "http://x/a.jpg", "", "", false, true},
+		{"json in place of media", []byte{0, 1}, "application/json", "http://x/a.png", "", "", false, true},
+	}
+	for _, tt := range tests {
+		ft, err := detectType(tt.head, tt.ctype, tt.url)
+		var de *DecodeError
+		if tt.page {
+			if !errors.As(err, &de) || !isFinal(err) {
+				t.Errorf("%s: err = %v, want a final *DecodeError", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ft.Ext != tt.wantExt || ft.Source != tt.wantSource || (ft.Mismatch != "") != tt.mismatch {
+			t.Errorf("%s: got %+v, want ext %s from %q, mismatch %v", tt.name, ft, tt.wantExt, tt.wantSource, tt.mismatch)
+		}
+	}
+}
+
+func TestURLExt(t *testing.T) {
+	tests := map[string]string{
+		"http://x/a.JPG":           ".jpg",
+		"http://x/a.webp?v=2#frag": ".webp",
+		"http://x/dir.d/file":      "",
+		"http://x/a.reallylongext": "",
+		"http://x/":                "",
+	}
+	for in, want := range tests {
+		if got := urlExt(in); got != want {
+			t.Errorf("urlExt(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := extToContentType(".jpeg"); got != "image/jpeg" {
+		t.Errorf("extToContentType(.jpeg) = %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Render modes: fetch the page with plain HTTP, with headless Chrome, or try HTTP first.
+const (
+	RenderStatic  = "static"
+	RenderBrowser = "browser"
+	RenderAuto    = "auto"
+)
+
+// maxStaticPage caps how much of a page FetchStatic reads.
+const maxStaticPage = 20 << 20
+
+// FetchStatic downloads a page's HTML over plain HTTP with browser-like headers.
+func FetchStatic(pageURL string) (string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", RandomUserAgent())
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Connection", "keep-alive")
+	cache := currentHTTPCache()
+	revalidating := cache != nil && cache.conditional(req)
+	hostLimiter.Wait(req.URL.Host)
+	resp, err := NewClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GET error: %w", classifyError(pageURL, err))
+	}
+	defer resp.Body.Close()
+	hostLimiter.Observe(req.URL.Host, resp)
+	if cache != nil {
+		defer flushHTTPCache() // one page, one write of the cache index
+	}
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		if body, ok := cache.page(req.URL.String()); ok {
+			return string(body), nil
+		}
+		return "", fmt.Errorf("not modified, but the cached copy of %s is gone", pageURL)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return "", &HTTPStatusError{URL: pageURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxStaticPage))
+	if err != nil {
+		return "", fmt.Errorf("read error: %w", err)
+	}
+	if cache != nil && resp.StatusCode == http.StatusOK {
+		if err := cache.storePage(req.URL.String(), resp.Header, body); err != nil {
+			return "", fmt.Errorf("cache page: %w", err)
+		}
+	}
+	return string(body), nil
+}
+
+// appRoots are mount points single-page apps render into.
+var appRoots = []string{"#root", "#app", "#__next", "#__nuxt", "[data-reactroot]", "app-root"}
+
+// LooksLikeJSShell reports whether statically fetched HTML is an empty shell that only
+// fills in after JavaScript runs, so the page needs a real browser.
+func LooksLikeJSShell(html string) bool {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return false
+	}
+	body := doc.Find("body")
+	for _, sel := range appRoots {
+		root := body.Find(sel).First()
+		if root.Length() > 0 && root.Children().Length() == 0 && strings.TrimSpace(root.Text()) == "" {
+			return true
+		}
+	}
+	noscript := strings.ToLower(doc.Find("noscript").Text())
+	if strings.Contains(noscript, "javascript") && (strings.Contains(noscript, "enable") || strings.Contains(noscript, "requires")) {
+		return true
+	}
+	// Scripts but hardly any text or images: content is built client-side.
+	content := body.Clone()
+	content.Find("script, style, noscript, template").Remove()
+	text := strings.Join(strings.Fields(content.Text()), " ")
+	return len(text) < 200 && body.Find("img").Length() == 0 && body.Find("script").Length() > 0
+}
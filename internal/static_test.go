@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLooksLikeJSShell(t *testing.T) {
+	article := "<p>" + strings.Repeat("A real article paragraph with words. ", 10) + "</p>"
+	tests := []struct {
+		name string
+		html string
+		want bool
+	}{
+		{"empty react root", `<html><body><div id="root"></div><script src="/app.js"></script></body></html>`, true},
+		{"empty next root", `<html><body><div id="__next">  </div></body></html>`, true},
+		{"angular root", `<html><body><app-root></app-root></body></html>`, true},
+		{"server-rendered root", `<html><body><div id="root"><img src="a.jpg"></div><script></script></body></html>`, false},
+		{"noscript notice", `<html><body>` + article + `<noscript>You need to enable JavaScript to run this app.</noscript></body></html>`, true},
+		{"scripts and no content", `<html><body><h1>Loading</h1><script>boot()</script></body></html>`, true},
+		{"scripts beside images", `<html><body><img src="a.jpg"><script>track()</script></body></html>`, false},
+		{"scripts beside text", `<html><body>` + article + `<script>track()</script></body></html>`, false},
+		{"plain page", `<html><body><h1>Hi</h1></body></html>`, false},
+		{"script text does not count as content", `<html><body><script>` + strings.Repeat("var x = 1; ", 50) + `</script></body></html>`, true},
+	}
+	for _, tt := range tests {
+		if got := LooksLikeJSShell(tt.html); got != tt.want {
+			t.Errorf("%s: LooksLikeJSShell = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
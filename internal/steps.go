@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Step actions understood by RenderPageWithOptions.
+const (
+	StepClick             = "click"               // click Selector once it is visible
+	StepWaitSelector      = "wait"                // wait until Selector is visible
+	StepSleep             = "sleep"               // pause for Duration
+	StepScroll            = "scroll"              // scroll Selector into view, or to the page bottom; Repeat times
+	StepType              = "type"                // type Text into Selector
+	StepEval              = "eval"                // evaluate Script in the page, awaiting a returned promise
+	StepRepeatUntilAbsent = "repeat_until_absent" // click Selector until it disappears, at most Repeat times
+)
+
+// defaultStepRepeat caps repeat_until_absent when Repeat is unset.
+const defaultStepRepeat = 20
+
+// Step is one declarative page interaction run between navigation and HTML capture.
+type Step struct {
+	Action   string   `json:"action"`
+	Selector string   `json:"selector,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Script   string   `json:"script,omitempty"`
+	Duration Duration `json:"duration,omitempty"`
+	Repeat   int      `json:"repeat,omitempty"`
+}
+
+func (s Step) validate() error {
+	switch s.Action {
+	case StepClick, StepWaitSelector, StepType, StepRepeatUntilAbsent:
+		if s.Selector == "" {
+			return fmt.Errorf("%s needs a selector", s.Action)
+		}
+	case StepEval:
+		if s.Script == "" {
+			return fmt.Errorf("eval needs a script")
+		}
+	case StepSleep:
+		if s.Duration <= 0 {
+			return fmt.Errorf("sleep needs a duration")
+		}
+	case StepScroll:
+	default:
+		return fmt.Errorf("unknown action %q", s.Action)
+	}
+	return nil
+}
+
+func (s Step) repeat() int {
+	if s.Repeat > 0 {
+		return s.Repeat
+	}
+	return defaultStepRepeat
+}
+
+// pause returns the step's Duration, or fallback when it is unset.
+func (s Step) pause(fallback time.Duration) time.Duration {
+	if s.Duration > 0 {
+		return time.Duration(s.Duration)
+	}
+	return fallback
+}
+
+// runSteps executes steps in order and reports which one failed.
+func runSteps(steps []Step) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for i, s := range steps {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("step %d (%s): %w", i+1, s.Action, err)
+			}
+			if err := s.run(ctx); err != nil {
+				return fmt.Errorf("step %d (%s %s): %w", i+1, s.Action, s.Selector, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s Step) run(ctx context.Context) error {
+	switch s.Action {
+	case StepClick:
+		return chromedp.Click(s.Selector, chromedp.ByQuery, chromedp.NodeVisible).Do(ctx)
+	case StepWaitSelector:
+		return chromedp.WaitVisible(s.Selector, chromedp.ByQuery).Do(ctx)
+	case StepSleep:
+		return chromedp.Sleep(time.Duration(s.Duration)).Do(ctx)
+	case StepType:
+		return chromedp.SendKeys(s.Selector, s.Text, chromedp.ByQuery, chromedp.NodeVisible).Do(ctx)
+	case StepEval:
+		var res any
+		return chromedp.Evaluate(s.Script, &res, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}).Do(ctx)
+	case StepScroll:
+		n := 1
+		if s.Repeat > 0 {
+			n = s.Repeat
+		}
+		for i := 0; i < n; i++ {
+			var err error
+			if s.Selector != "" {
+				err = chromedp.ScrollIntoView(s.Selector, chromedp.ByQuery).Do(ctx)
+			} else {
+				var res any
+				err = chromedp.Evaluate(`window.scrollTo(0, document.documentElement.scrollHeight)`, &res).Do(ctx)
+			}
+			if err != nil {
+				return err
+			}
+			if err := chromedp.Sleep(s.pause(500 * time.Millisecond)).Do(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	case StepRepeatUntilAbsent:
+		sel, _ := json.Marshal(s.Selector)
+		for i := 0; i < s.repeat(); i++ {
+			var clicked bool
+			js := fmt.Sprintf(`(() => { const el = document.querySelector(%s); if (!el) return false; el.click(); return true; })()`, sel)
+			if err := chromedp.Evaluate(js, &clicked).Do(ctx); err != nil {
+				return err
+			}
+			if !clicked {
+				return nil
+			}
+			if err := chromedp.Sleep(s.pause(time.Second)).Do(ctx); err != nil {
+				return err
+			}
+		}
+		// Endless feeds never run out of "load more"; stop at the cap rather than fail the render.
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", s.Action)
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TransportConfig tunes the HTTP transport shared by every fetch.
+type TransportConfig struct {
+	MaxIdleConns          int           `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `json:"max_conns_per_host"` // 0 is unlimited
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout"`
+	DialTimeout           time.Duration `json:"dial_timeout"`
+	KeepAlive             time.Duration `json:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout"` // 0 waits as long as the client timeout, if any
+	HTTP2                 bool          `json:"http2"`
+	// PerHost gives every host its own transport and connection pool instead of one shared pool.
+	PerHost bool `json:"per_host"`
+}
+
+// DefaultTransportConfig keeps a few idle connections per host, enough for the download workers
+// and chunked ranges to reuse them.
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           15 * time.Second,
+	KeepAlive:             30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	HTTP2:                 true,
+}
+
+// ConnStats counts the connections requests went out on.
+type ConnStats struct {
+	Host   string `json:"host,omitempty"`
+	New    int64  `json:"new"`
+	Reused int64  `json:"reused"`
+}
+
+// transportKey picks a transport: by host when PerHost is set, and apart for the insecure tier.
+type transportKey struct {
+	host     string
+	insecure bool
+}
+
+var (
+	transportMu  sync.Mutex
+	transportCfg = DefaultTransportConfig
+	transports   = map[transportKey]*http.Transport{}
+
+	connStatsMu sync.Mutex
+	connStats   = map[string]*ConnStats{}
+)
+
+// SetTransportConfig replaces the transport settings; open idle connections are closed and
+// new ones follow c.
+func SetTransportConfig(c TransportConfig) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+	transportCfg = c
+	transports = map[transportKey]*http.Transport{}
+}
+
+// CurrentTransportConfig returns the transport settings in use.
+func CurrentTransportConfig() TransportConfig {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	return transportCfg
+}
+
+// newTransport builds a transport from c; insecure skips certificate verification.
+func (c TransportConfig) newTransport(insecure bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     c.HTTP2,
+	}
+	if insecure {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if !c.HTTP2 {
+		// A non-nil empty map is how net/http is told not to negotiate HTTP/2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// transportFor returns the pooled transport for host, creating it on first use.
+func transportFor(host string, insecure bool) *http.Transport {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	key := transportKey{insecure: insecure}
+	if transportCfg.PerHost {
+		key.host = host
+	}
+	t, ok := transports[key]
+	if !ok {
+		t = transportCfg.newTransport(insecure)
+		transports[key] = t
+	}
+	return t
+}
+
+// sharedTransport routes each request to its pooled transport and counts whether it got a
+// new or a reused connection.
+type sharedTransport struct {
+	insecure bool
+}
+
+func (s sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { recordConn(host, info.Reused) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return transportFor(host, s.insecure).RoundTrip(req)
+}
+
+// idleTimeoutTransport fails a response body that delivers nothing for idle. Only time spent
+// waiting on the connection counts, not time the caller spends between reads.
+type idleTimeoutTransport struct {
+	base http.RoundTripper
+	idle time.Duration
+}
+
+func (t idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &idleReader{rc: resp.Body, idle: t.idle, cancel: cancel}
+	return resp, nil
+}
+
+// idleReader cancels its request when a single read waits longer than idle.
+type idleReader struct {
+	rc     io.ReadCloser
+	idle   time.Duration
+	cancel context.CancelFunc
+}
+
+// idleTimeoutError is the error of a body read cut off by idleReader.
+type idleTimeoutError struct{ idle time.Duration }
+
+func (e idleTimeoutError) Error() string   { return fmt.Sprintf("no data received for %v", e.idle) }
+func (e idleTimeoutError) Timeout() bool   { return true }
+func (e idleTimeoutError) Temporary() bool { return true }
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(r.idle, r.cancel)
+	n, err := r.rc.Read(p)
+	if !timer.Stop() && err != nil {
+		err = idleTimeoutError{r.idle}
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.cancel()
+	return r.rc.Close()
+}
+
+// HTTPTransport is the shared transport for normal requests.
+func HTTPTransport() http.RoundTripper { return sharedTransport{} }
+
+// insecureTransport is the shared transport for the insecure-tls tier.
+func insecureTransport() http.RoundTripper { return sharedTransport{insecure: true} }
+
+func recordConn(host string, reused bool) {
+	connStatsMu.Lock()
+	defer connStatsMu.Unlock()
+	s, ok := connStats[host]
+	if !ok {
+		s = &ConnStats{Host: host}
+		connStats[host] = s
+	}
+	if reused {
+		s.Reused++
+	} else {
+		s.New++
+	}
+}
+
+// ConnectionStats returns the connection counts over all hosts and per host, sorted by name.
+func ConnectionStats() (ConnStats, []ConnStats) {
+	connStatsMu.Lock()
+	defer connStatsMu.Unlock()
+	var total ConnStats
+	hosts := make([]ConnStats, 0, len(connStats))
+	for _, s := range connStats {
+		total.New += s.New
+		total.Reused += s.Reused
+		hosts = append(hosts, *s)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return total, hosts
+}
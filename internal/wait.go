@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Wait strategy kinds understood by RenderPageWithOptions.
+const (
+	WaitNetworkIdle = "network_idle" // no more than Count requests in flight for Duration (default 500ms)
+	WaitSelector    = "selector"     // Selector is present in the DOM
+	WaitCount       = "count"        // at least Count elements match Selector (default "img")
+	WaitJS          = "js"           // Expr evaluates truthy
+	WaitDelay       = "delay"        // fixed pause of Duration
+)
+
+// pollInterval is how often count, js and network_idle waits re-check the page.
+const pollInterval = 100 * time.Millisecond
+
+// defaultWaitTimeout is how long a wait may take when its strategy sets no Timeout.
+const defaultWaitTimeout = 15 * time.Second
+
+// WaitStrategy is one condition the page must reach before its HTML is captured.
+// Strategies run in order, after any interaction steps.
+type WaitStrategy struct {
+	Kind     string   `json:"kind"`
+	Selector string   `json:"selector,omitempty"`
+	Count    int      `json:"count,omitempty"`
+	Expr     string   `json:"expr,omitempty"`
+	Duration Duration `json:"duration,omitempty"`
+	// Timeout is how long the wait may take (default 15s); past it a warning is recorded and
+	// the page is captured as it is.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+func (w WaitStrategy) validate() error {
+	switch w.Kind {
+	case WaitNetworkIdle, WaitCount:
+	case WaitSelector:
+		if w.Selector == "" {
+			return fmt.Errorf("selector wait needs a selector")
+		}
+	case WaitJS:
+		if w.Expr == "" {
+			return fmt.Errorf("js wait needs an expr")
+		}
+	case WaitDelay:
+		if w.Duration <= 0 {
+			return fmt.Errorf("delay wait needs a duration")
+		}
+	default:
+		return fmt.Errorf("unknown wait kind %q", w.Kind)
+	}
+	return nil
+}
+
+// PhaseTiming is how long one phase of a render took.
+type PhaseTiming struct {
+	Phase     string `json:"phase"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+}
+
+// timed runs action and appends its elapsed time to timings, even when it fails.
+func timed(timings *[]PhaseTiming, phase string, action chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		start := time.Now()
+		err := action.Do(ctx)
+		*timings = append(*timings, PhaseTiming{Phase: phase, ElapsedMS: time.Since(start).Milliseconds()})
+		return err
+	})
+}
+
+// inflightTracker counts the page's outstanding network requests.
+type inflightTracker struct {
+	mu       sync.Mutex
+	inflight map[network.RequestID]struct{}
+	lastDone time.Time
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{inflight: make(map[network.RequestID]struct{}), lastDone: time.Now()}
+}
+
+func (t *inflightTracker) onEvent(ev any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		t.inflight[ev.RequestID] = struct{}{}
+	case *network.EventLoadingFinished:
+		delete(t.inflight, ev.RequestID)
+		t.lastDone = time.Now()
+	case *network.EventLoadingFailed:
+		delete(t.inflight, ev.RequestID)
+		t.lastDone = time.Now()
+	}
+}
+
+// idleFor reports whether at most max requests have been in flight for at least d.
+func (t *inflightTracker) idleFor(max int, d time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inflight) <= max && time.Since(t.lastDone) >= d
+}
+
+// action turns a strategy into a chromedp action; tracker is needed for network_idle.
+func (w WaitStrategy) action(tracker *inflightTracker) chromedp.Action {
+	switch w.Kind {
+	case WaitNetworkIdle:
+		idle := time.Duration(w.Duration)
+		if idle <= 0 {
+			idle = 500 * time.Millisecond
+		}
+		return chromedp.ActionFunc(func(ctx context.Context) error {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+			for !tracker.idleFor(w.Count, idle) {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ticker.C:
+				}
+			}
+			return nil
+		})
+	case WaitSelector:
+		return chromedp.WaitReady(w.Selector, chromedp.ByQuery)
+	case WaitCount:
+		sel := w.Selector
+		if sel == "" {
+			sel = "img"
+		}
+		n := w.Count
+		if n <= 0 {
+			n = 1
+		}
+		arg, _ := json.Marshal(sel)
+		return chromedp.Poll(fmt.Sprintf(`document.querySelectorAll(%s).length >= %d`, arg, n), nil, chromedp.WithPollingInterval(pollInterval), chromedp.WithPollingTimeout(0))
+	case WaitJS:
+		return chromedp.Poll(w.Expr, nil, chromedp.WithPollingInterval(pollInterval), chromedp.WithPollingTimeout(0))
+	case WaitDelay:
+		return chromedp.Sleep(time.Duration(w.Duration))
+	}
+	return chromedp.ActionFunc(func(context.Context) error { return w.validate() })
+}
+
+// runWaits executes strategies in order, timing each one. A wait that runs out of time adds
+// to warnings and the next one starts.
+func runWaits(waits []WaitStrategy, tracker *inflightTracker, timings *[]PhaseTiming, warnings *[]string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for i, w := range waits {
+			if err := w.validate(); err != nil {
+				return fmt.Errorf("wait %d: %w", i+1, err)
+			}
+			limit := time.Duration(w.Timeout)
+			if limit <= 0 {
+				limit = defaultWaitTimeout
+			}
+			wctx, cancel := context.WithTimeout(ctx, limit)
+			err := timed(timings, "wait:"+w.Kind, w.action(tracker)).Do(wctx)
+			timedOut := wctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+			cancel()
+			if err != nil && timedOut {
+				*warnings = append(*warnings, fmt.Sprintf("wait %d (%s): gave up after %v", i+1, w.Kind, limit))
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("wait %d (%s): %w", i+1, w.Kind, err)
+			}
+		}
+		return nil
+	})
+}
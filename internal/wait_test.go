@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestRunWaitsTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		inflight int
+		count    int
+		warnings int
+	}{
+		{"idle", 0, 0, 0},
+		{"within tolerance", 2, 2, 0},
+		{"never idle", 3, 2, 1},
+	}
+	for _, tt := range tests {
+		tracker := newInflightTracker()
+		tracker.lastDone = time.Now().Add(-time.Second)
+		for i := 0; i < tt.inflight; i++ {
+			tracker.inflight[network.RequestID(strings.Repeat("r", i+1))] = struct{}{}
+		}
+		waits := []WaitStrategy{{Kind: WaitNetworkIdle, Count: tt.count, Duration: Duration(10 * time.Millisecond), Timeout: Duration(200 * time.Millisecond)}}
+		var timings []PhaseTiming
+		var warnings []string
+		if err := runWaits(waits, tracker, &timings, &warnings).Do(context.Background()); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(warnings) != tt.warnings || len(timings) != 1 {
+			t.Errorf("%s: warnings %q, timings %v", tt.name, warnings, timings)
+		}
+	}
+}
+
+func TestRunWaitsCancelled(t *testing.T) {
+	tracker := newInflightTracker()
+	tracker.inflight["r"] = struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var timings []PhaseTiming
+	var warnings []string
+	// The render's own deadline still fails the render instead of counting as a slow wait.
+	err := runWaits([]WaitStrategy{{Kind: WaitNetworkIdle}}, tracker, &timings, &warnings).Do(ctx)
+	if err == nil || len(warnings) != 0 {
+		t.Errorf("err = %v, warnings %q", err, warnings)
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"img-scraper/internal"
@@ -38,6 +45,33 @@ var formTmpl = `
 			<label>URL:
 				<input type="text" name="url" placeholder="https://example.com" required>
 			</label>
+			<label>Render mode:
+				<select name="mode">
+					<option value="auto" selected>Auto (static first, browser if needed)</option>
+					<option value="static">Static (plain HTTP)</option>
+					<option value="browser">Browser (headless Chrome)</option>
+				</select>
+			</label>
+			<label><input type="checkbox" name="network" value="1" checked> Harvest media from network traffic</label>
+			<label><input type="checkbox" name="bodies" value="1" checked> Save bodies straight from the browser</label>
+			<label>Wait for:
+				<select name="wait">
+					<option value="">Page body ready</option>
+					<option value="network_idle" selected>Network idle</option>
+					<option value="count">At least one image</option>
+				</select>
+			</label>
+			<label>Emulate devices (one render each, candidates merged):
+				<input type="checkbox" name="device" value="desktop"> Desktop
+				<input type="checkbox" name="device" value="mobile"> Mobile
+				<input type="checkbox" name="device" value="tablet"> Tablet
+			</label>
+			<label><input type="checkbox" name="block" value="1" checked> Block fonts, video autoplay, ads and analytics</label>
+			<label><input type="checkbox" name="canvas" value="1"> Export canvases and blob: media</label>
+			<label><input type="checkbox" name="screenshot" value="1"> Full-page screenshot</label>
+			<label><input type="checkbox" name="pdf" value="1"> PDF capture</label>
+			<label>Near-duplicate images: keep only the largest when hashes differ by at most
+				<input type="number" name="near_dup" min="0" max="64" placeholder="off"> bits</label>
 			<input type="submit" value="Scrape">
 		</form>
 		<div class="footer">&copy; 2025 Image Scraper</div>
@@ -45,7 +79,106 @@ var formTmpl = `
 </body>
 </html>`
 
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
 func main() {
+	var (
+		chromeURL   = flag.String("chrome-url", "", "DevTools endpoint of a running Chrome (ws://host:9222/devtools/browser/<id> or http://host:9222)")
+		headful     = flag.Bool("headful", false, "launch local Chrome with a visible window")
+		userDataDir = flag.String("user-data-dir", "", "Chrome profile directory for locally launched Chrome")
+		windowSize  = flag.String("window-size", "", "window size for locally launched Chrome, e.g. 1366x768")
+		dedup       = flag.String("dedup", internal.DedupHardlink, "what to do with files whose content was downloaded before: off, skip or hardlink")
+		nameTmpl    = flag.String("name-template", internal.DefaultNameTemplate, "output file name template, e.g. {host}/{url_path}/{basename}_{sha256:8}{ext}")
+		cacheOn     = flag.Bool("http-cache", true, "revalidate repeat downloads and page fetches with ETag/Last-Modified instead of fetching them again")
+		cacheAge    = flag.Duration("http-cache-max-age", 30*24*time.Hour, "forget cache entries not used for this long")
+		cacheBytes  = flag.Int64("http-cache-max-bytes", 200<<20, "cap on cached page bodies, in bytes")
+		hostRate    = flag.Float64("host-rate", internal.DefaultHostRate, "requests per second per host, shared by all jobs; slowed down automatically on 429/503")
+		hostBurst   = flag.Int("host-burst", 1, "requests per host allowed back to back")
+		cookieFile  = flag.String("cookie-file", "", "keep download cookies in this file across batches and restarts, e.g. cookies.json (not under Downloaded/, which is served); empty gives each batch its own")
+		accept      = internal.DefaultAcceptFilter
+		transport   = internal.DefaultTransportConfig
+		bandwidth   internal.BandwidthLimit
+		chromeFlags stringList
+	)
+	flag.Var(&chromeFlags, "chrome-flag", "extra Chrome switch as name or name=value (repeatable)")
+	flag.IntVar(&accept.MinWidth, "min-width", 0, "reject images narrower than this many pixels")
+	flag.IntVar(&accept.MinHeight, "min-height", 0, "reject images shorter than this many pixels")
+	flag.IntVar(&accept.MaxWidth, "max-width", 0, "reject images wider than this many pixels")
+	flag.IntVar(&accept.MaxHeight, "max-height", 0, "reject images taller than this many pixels")
+	flag.Float64Var(&accept.MinAspect, "min-aspect", 0, "reject images whose width/height is below this")
+	flag.Float64Var(&accept.MaxAspect, "max-aspect", 0, "reject images whose width/height is above this")
+	flag.Int64Var(&accept.MinBytes, "min-bytes", accept.MinBytes, "reject files smaller than this many bytes")
+	flag.Int64Var(&accept.MaxBytes, "max-bytes", 0, "reject files larger than this many bytes")
+	flag.IntVar(&transport.MaxIdleConns, "max-idle-conns", transport.MaxIdleConns, "idle connections kept open across all hosts")
+	flag.IntVar(&transport.MaxIdleConnsPerHost, "max-idle-conns-per-host", transport.MaxIdleConnsPerHost, "idle connections kept open per host")
+	flag.IntVar(&transport.MaxConnsPerHost, "max-conns-per-host", 0, "cap on open connections per host (0 is unlimited)")
+	flag.DurationVar(&transport.IdleConnTimeout, "idle-conn-timeout", transport.IdleConnTimeout, "close idle connections after this long")
+	flag.DurationVar(&transport.DialTimeout, "dial-timeout", transport.DialTimeout, "timeout for opening a TCP connection")
+	flag.DurationVar(&transport.TLSHandshakeTimeout, "tls-handshake-timeout", transport.TLSHandshakeTimeout, "timeout for the TLS handshake")
+	flag.DurationVar(&transport.ResponseHeaderTimeout, "response-header-timeout", transport.ResponseHeaderTimeout, "timeout for the response headers after a request is sent")
+	flag.BoolVar(&transport.HTTP2, "http2", transport.HTTP2, "negotiate HTTP/2 with servers that support it")
+	flag.BoolVar(&transport.PerHost, "per-host-transport", false, "give every host its own connection pool")
+	flag.Int64Var(&bandwidth.Global, "max-bandwidth", 0, "cap on download speed for the whole process, in bytes per second (0 is unlimited)")
+	flag.Int64Var(&bandwidth.PerHost, "max-host-bandwidth", 0, "cap on download speed per host, in bytes per second (0 is unlimited)")
+	flag.Parse()
+	internal.SetAcceptFilter(accept)
+	internal.SetBandwidthLimit(bandwidth)
+	internal.SetTransportConfig(transport)
+	if *hostRate <= 0 {
+		log.Fatalf("-host-rate must be positive")
+	}
+	internal.SetHostRate(*hostRate, *hostBurst)
+	browserCfg := internal.BrowserConfig{
+		RemoteURL:   *chromeURL,
+		Headful:     *headful,
+		UserDataDir: *userDataDir,
+		Flags:       chromeFlags,
+	}
+	if *windowSize != "" {
+		if _, err := fmt.Sscanf(*windowSize, "%dx%d", &browserCfg.WindowWidth, &browserCfg.WindowHeight); err != nil {
+			log.Fatalf("invalid -window-size %q: want WIDTHxHEIGHT", *windowSize)
+		}
+	}
+	internal.SetBrowserConfig(browserCfg)
+
+	tmpl, err := internal.ParseNameTemplate(*nameTmpl)
+	if err != nil {
+		log.Fatalf("-name-template: %v", err)
+	}
+	internal.SetNameTemplate(tmpl)
+
+	os.MkdirAll("Downloaded", 0755)
+	index, err := internal.OpenContentIndex("Downloaded", *dedup)
+	if err != nil {
+		log.Fatalf("content index: %v", err)
+	}
+	internal.SetContentIndex(index)
+	if *cacheOn {
+		cache, err := internal.OpenHTTPCache("Downloaded", *cacheAge, *cacheBytes)
+		if err != nil {
+			log.Fatalf("http cache: %v", err)
+		}
+		internal.SetHTTPCache(cache)
+	}
+
+	if *cookieFile != "" {
+		jar, err := internal.NewCookieJar(*cookieFile)
+		if err != nil {
+			log.Fatalf("cookie file: %v", err)
+		}
+		internal.SetCookieJar(jar)
+	}
+
+	profiles, err := internal.LoadSiteProfiles("sites.json")
+	if err != nil {
+		log.Fatalf("site profiles: %v", err)
+	}
+	resumeJobs("Downloaded")
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			fmt.Fprintf(w, "<html><body>%s</body></html>", formTmpl)
@@ -62,25 +195,409 @@ func main() {
 			return
 		}
 		os.MkdirAll("Downloaded", 0755)
-		html, err := internal.RenderPage(url, 50*time.Second)
+		job, err := internal.NewJob("Downloaded", url)
 		if err != nil {
+			fmt.Fprintf(w, "<html><body>%s<p style='color:red'>Job setup error: %v</p></body></html>", formTmpl, err)
+			return
+		}
+		opts := internal.RenderOptions{
+			Timeout:       50 * time.Second,
+			CaptureMedia:  r.FormValue("network") != "",
+			CaptureBodies: r.FormValue("bodies") != "",
+			CaptureCanvas: r.FormValue("canvas") != "",
+			Screenshot:    r.FormValue("screenshot") != "",
+			PDF:           r.FormValue("pdf") != "",
+		}
+		if kind := r.FormValue("wait"); kind != "" {
+			w := internal.WaitStrategy{Kind: kind}
+			if kind == internal.WaitNetworkIdle {
+				w.Count = 2 // long-polling and analytics requests may never finish
+			}
+			opts.Waits = []internal.WaitStrategy{w}
+		}
+		if r.FormValue("block") != "" {
+			opts.Intercept = internal.DefaultIntercept()
+		}
+		if p := internal.ProfileFor(profiles, url); p != nil {
+			opts.Steps = p.Steps
+			if len(p.Waits) > 0 {
+				opts.Waits = p.Waits
+			}
+			if p.Intercept != nil {
+				opts.Intercept = p.Intercept
+			}
+		}
+		var devices []internal.EmulationProfile
+		for _, name := range r.Form["device"] {
+			if preset, ok := internal.EmulationPresets[name]; ok {
+				devices = append(devices, preset)
+			}
+		}
+		if p := internal.ProfileFor(profiles, url); p != nil && len(devices) == 0 {
+			devices, _ = p.EmulationProfiles() // validated when sites.json was loaded
+		}
+		mode := r.FormValue("mode")
+		if mode == "" {
+			mode = internal.RenderAuto
+		}
+		outcome, err := fetchCandidates(url, mode, opts, devices)
+		if err != nil {
+			job.Fail(err)
 			fmt.Fprintf(w, "<html><body>%s<p>Page render error: %v</p></body></html>", formTmpl, err)
 			return
 		}
-		var result string
-		imageURLs, err := internal.ExtractImageURLs(html, url)
+		job.RenderMode, job.RenderReason = outcome.mode, outcome.reason
+		job.IgnoredOptions = outcome.ignored
+		imageURLs := outcome.urls
+		result := fmt.Sprintf("<p>Render mode: %s (%s)</p>", outcome.mode, html.EscapeString(outcome.reason))
+		if len(outcome.ignored) > 0 {
+			result += fmt.Sprintf("<p>Not used without the browser: %s</p>", strings.Join(outcome.ignored, ", "))
+		}
+		for _, w := range outcome.warnings {
+			result += fmt.Sprintf("<p>Render warning: %s</p>", html.EscapeString(w))
+		}
+		var media []internal.CapturedMedia
+		for _, page := range outcome.pages {
+			if page.profile != "" {
+				job.Profiles = append(job.Profiles, page.profile)
+				result += fmt.Sprintf("<h4>Profile: %s</h4>", html.EscapeString(page.profile))
+			}
+			job.Stats.Allowed += page.Intercept.Allowed
+			job.Stats.Blocked += page.Intercept.Blocked
+			if opts.Intercept != nil && outcome.mode == internal.RenderBrowser {
+				result += fmt.Sprintf("<p>Requests allowed: %d, blocked: %d</p>", page.Intercept.Allowed, page.Intercept.Blocked)
+			}
+			for _, t := range page.Timings {
+				if page.profile != "" {
+					t.Phase = page.profile + "/" + t.Phase
+				}
+				job.RenderTimings = append(job.RenderTimings, t)
+				result += fmt.Sprintf("<p>%s: %d ms</p>", html.EscapeString(t.Phase), t.ElapsedMS)
+			}
+			result += saveRenderArtifacts(job, page)
+			media = append(media, page.Media...)
+		}
+		saved, capturedResults, err := internal.SaveCapturedMedia(uniqueMedia(media), job.Dir, 1)
 		if err != nil {
-			result += fmt.Sprintf("<p>Image extraction error: %v</p>", err)
-		} else if len(imageURLs) > 0 {
+			result += fmt.Sprintf("<p>Browser media save error: %v</p>", err)
+		}
+		byOrigin := map[string]int{}
+		for _, m := range saved {
+			if rel, err := filepath.Rel(job.Dir, m.Path); err == nil {
+				m.Path = rel // a duplicate may live in an earlier job's directory
+			}
+			job.Captured = append(job.Captured, m)
+			byOrigin[m.Origin]++
+		}
+		for _, origin := range []string{internal.OriginNetwork, internal.OriginCanvas, internal.OriginBlob} {
+			if n := byOrigin[origin]; n > 0 {
+				result += fmt.Sprintf("<p>Saved %d file(s) from the browser (%s)</p>", n, origin)
+			}
+		}
+		job.Candidates = imageURLs
+		if err := job.Save(); err != nil {
+			log.Printf("save manifest for %s: %v", job.ID, err)
+		}
+		batch := &internal.BatchResult{}
+		if len(imageURLs) > 0 {
 			result += fmt.Sprintf("<p>Found %d image files</p>", len(imageURLs))
-			internal.DownloadImagesAdvancedBatch(imageURLs, url, "Downloaded")
-			result += "<p>Downloaded images to Downloaded/</p>"
-		} else {
+			batch = internal.DownloadImagesAdvancedBatch(imageURLs, url, job.Dir)
+			result += fmt.Sprintf("<p>Downloaded images to %s/</p>", job.Dir)
+		} else if len(saved) == 0 {
 			result += "<p>No image URLs found.</p>"
 		}
+		batch.Merge(capturedResults)
+		// Near-duplicates move first, so the report names the files where they end up.
+		if v := r.FormValue("near_dup"); v != "" {
+			if dist, err := strconv.Atoi(v); err == nil && dist >= 0 {
+				result += dropNearDuplicates(job, dist, batch)
+			}
+		}
+		if len(batch.Results) > 0 {
+			if err := job.SaveReport(batch); err != nil {
+				result += fmt.Sprintf("<p>Report save error: %v</p>", err)
+			}
+			result += reportTable(job, batch)
+		}
+		if err := job.Finish(); err != nil {
+			log.Printf("save manifest for %s: %v", job.ID, err)
+		}
+		result += jobLinks(job)
 		fmt.Fprintf(w, "<html><body>%s%s</body></html>", formTmpl, result)
 	})
 
+	http.HandleFunc("/api/ratelimit", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(internal.RateLimitState())
+	})
+
+	http.HandleFunc("/api/bandwidth", func(w http.ResponseWriter, r *http.Request) {
+		// GET shows the caps; POST a BandwidthLimit as JSON to change them for running jobs too.
+		if r.Method == http.MethodPost {
+			var l internal.BandwidthLimit
+			if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+				http.Error(w, fmt.Sprintf("invalid bandwidth limit: %v", err), http.StatusBadRequest)
+				return
+			}
+			if l.Global < 0 || l.PerHost < 0 {
+				http.Error(w, "bandwidth limits must not be negative", http.StatusBadRequest)
+				return
+			}
+			for host, v := range l.Hosts {
+				if v < 0 {
+					http.Error(w, fmt.Sprintf("bandwidth limit for %s must not be negative", host), http.StatusBadRequest)
+					return
+				}
+			}
+			internal.SetBandwidthLimit(l)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(internal.CurrentBandwidthLimit())
+	})
+
+	http.HandleFunc("/api/transport", func(w http.ResponseWriter, r *http.Request) {
+		total, hosts := internal.ConnectionStats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"config":      internal.CurrentTransportConfig(),
+			"connections": total,
+			"hosts":       hosts,
+		})
+	})
+
+	http.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		// /api/jobs/<id> is the manifest, /api/jobs/<id>/report the download report
+		// (?format=csv for CSV).
+		id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+		job, err := internal.LoadJob("Downloaded", id)
+		if err != nil || id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		switch sub {
+		case "":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(job)
+		case "report":
+			name, ctype := internal.ReportJSON, "application/json"
+			if r.URL.Query().Get("format") == "csv" {
+				name, ctype = internal.ReportCSV, "text/csv"
+			}
+			data, err := os.ReadFile(filepath.Join(job.Dir, name))
+			if err != nil {
+				http.Error(w, "no report for this job yet", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	http.Handle("/jobs/", http.StripPrefix("/jobs/", http.FileServer(jobFS{http.Dir("Downloaded")})))
+
 	fmt.Println("Web UI running at http://localhost:8080/")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
+
+// mergeCandidates combines DOM and network candidate URLs, dropping duplicates and
+// anything whose bytes were already saved from the browser.
+func mergeCandidates(domURLs, networkURLs []string, media []internal.CapturedMedia) []string {
+	seen := map[string]struct{}{}
+	for _, m := range media {
+		if len(m.Body) > 0 {
+			seen[m.URL] = struct{}{}
+		}
+	}
+	var out []string
+	for _, list := range [][]string{domURLs, networkURLs} {
+		for _, u := range list {
+			if _, ok := seen[u]; ok {
+				continue
+			}
+			seen[u] = struct{}{}
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// saveRenderArtifacts stores the screenshot and PDF of a render in the job and reports what happened.
+// Renders under an emulation profile get the profile name in their file names.
+func saveRenderArtifacts(job *internal.Job, page renderedPage) string {
+	var result, suffix string
+	if page.profile != "" {
+		suffix = "-" + page.profile
+	}
+	if len(page.Screenshot) > 0 {
+		if err := job.WriteArtifact("screenshot", "screenshot"+suffix+".png", page.Screenshot); err != nil {
+			result += fmt.Sprintf("<p>Screenshot save error: %v</p>", err)
+		}
+	}
+	if len(page.PDF) > 0 {
+		if err := job.WriteArtifact("pdf", "page"+suffix+".pdf", page.PDF); err != nil {
+			result += fmt.Sprintf("<p>PDF save error: %v</p>", err)
+		}
+	}
+	for _, w := range page.Warnings {
+		result += fmt.Sprintf("<p>Capture warning: %s</p>", html.EscapeString(w))
+	}
+	return result
+}
+
+// dropNearDuplicates clusters the job's images by perceptual hash, moves all but the
+// highest-resolution image of each cluster aside and records the clusters in the manifest.
+// Results in batch that named a moved file are pointed at its new place.
+func dropNearDuplicates(job *internal.Job, maxDistance int, batch *internal.BatchResult) string {
+	files, err := job.MediaFiles()
+	if err != nil {
+		return fmt.Sprintf("<p>Near-duplicate scan error: %v</p>", err)
+	}
+	var hashes []internal.ImageHash
+	for _, f := range files {
+		if h, err := internal.HashImageFile(f); err == nil {
+			hashes = append(hashes, h) // videos and undecodable files are left alone
+		}
+	}
+	clusters := internal.ClusterNearDuplicates(hashes, maxDistance)
+	var before []string
+	for _, c := range clusters {
+		for _, d := range c.Dropped {
+			rel, _ := filepath.Rel(job.Dir, d.Path)
+			before = append(before, rel)
+		}
+	}
+	err = internal.SetAsideNearDuplicates(job.Dir, clusters)
+	dropped := 0
+	moved := map[string]string{}
+	for i := range clusters {
+		c := &clusters[i]
+		c.Keep.Path, _ = filepath.Rel(job.Dir, c.Keep.Path)
+		for j := range c.Dropped {
+			c.Dropped[j].Path, _ = filepath.Rel(job.Dir, c.Dropped[j].Path)
+			moved[before[dropped]] = c.Dropped[j].Path
+			dropped++
+		}
+	}
+	for i, r := range batch.Results {
+		if to, ok := moved[r.File]; ok {
+			batch.Results[i].File = to
+		}
+	}
+	if err != nil {
+		return fmt.Sprintf("<p>Near-duplicate move error: %v</p>", err)
+	}
+	job.NearDuplicates = clusters
+	if len(clusters) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<p>Found %d near-duplicate cluster(s); moved %d lower-resolution copies to near-duplicates/</p>", len(clusters), dropped)
+}
+
+// reportTable renders the per-URL results of a download batch.
+func reportTable(job *internal.Job, batch *internal.BatchResult) string {
+	result := fmt.Sprintf("<p>Succeeded: %d, rejected: %d, failed: %d (%d bytes in %d ms, connections: %d new, %d reused)</p>",
+		batch.Succeeded, batch.Rejected, batch.Failed, batch.Bytes, batch.DurationMS, batch.Connections.New, batch.Connections.Reused)
+	result += "<table><tr><th>URL</th><th>Status</th><th>HTTP</th><th>Bytes</th><th>ms</th><th>Attempts</th><th>Method</th><th>File / error</th></tr>"
+	for _, r := range batch.Results {
+		detail := html.EscapeString(r.Error)
+		if r.File != "" {
+			detail = html.EscapeString(r.File)
+			// ../<job>/... is the copy a dedup skip points at in another job.
+			if link := path.Join("/jobs", job.ID, filepath.ToSlash(r.File)); !filepath.IsAbs(r.File) && strings.HasPrefix(link, "/jobs/") {
+				detail = fmt.Sprintf("<a href='%s' target='_blank'>%s</a>", link, detail)
+			}
+		}
+		result += fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%d</td><td>%s</td><td>%s</td></tr>",
+			html.EscapeString(r.URL), r.Status, r.HTTPCode, r.Bytes, r.DurationMS, r.Attempts, r.Method, detail)
+	}
+	return result + "</table>"
+}
+
+// uniqueMedia keeps one capture per URL across profiles, preferring one that has a body.
+func uniqueMedia(media []internal.CapturedMedia) []internal.CapturedMedia {
+	seen := map[string]int{}
+	var out []internal.CapturedMedia
+	for _, m := range media {
+		if i, ok := seen[m.URL]; ok {
+			if out[i].Body == nil && m.Body != nil {
+				out[i] = m
+			}
+			continue
+		}
+		seen[m.URL] = len(out)
+		out = append(out, m)
+	}
+	return out
+}
+
+// jobLinks renders links to a job's manifest and artifacts.
+func jobLinks(job *internal.Job) string {
+	result := fmt.Sprintf("<div class='result'><p>Job <a href='/jobs/%s/'>%s</a> (<a href='/jobs/%s/manifest.json'>manifest</a>)</p>", job.ID, job.ID, job.ID)
+	for _, a := range job.Artifacts {
+		result += fmt.Sprintf("<p><a href='/jobs/%s/%s' target='_blank'>%s</a> (%d bytes)</p>", job.ID, a.Path, a.Kind, a.Bytes)
+	}
+	return result + "</div>"
+}
+
+// jobFS serves only what is inside job directories, and no hidden files: the output root also
+// holds the content index, the HTTP cache and possibly other state that is not for the web.
+type jobFS struct {
+	fs http.FileSystem
+}
+
+func (j jobFS) Open(name string) (http.File, error) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if !strings.HasPrefix(parts[0], "job_") {
+		return nil, os.ErrNotExist
+	}
+	for _, p := range parts {
+		if strings.HasPrefix(p, ".") {
+			return nil, os.ErrNotExist
+		}
+	}
+	f, err := j.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hiddenFilter{f}, nil
+}
+
+// hiddenFilter leaves hidden entries out of directory listings.
+type hiddenFilter struct {
+	http.File
+}
+
+func (f hiddenFilter) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := f.File.Readdir(n)
+	var out []os.FileInfo
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			out = append(out, e)
+		}
+	}
+	return out, err
+}
+
+// resumeJobs continues, in the background, the downloads of jobs that were interrupted by a
+// restart. Partially downloaded files pick up where they stopped; finished ones are skipped.
+func resumeJobs(rootDir string) {
+	jobs, err := internal.IncompleteJobs(rootDir)
+	if err != nil {
+		log.Printf("scan for interrupted jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		log.Printf("resuming job %s (%d candidates)", job.ID, len(job.Candidates))
+		go func(job *internal.Job) {
+			batch := internal.DownloadImagesAdvancedBatch(job.Candidates, job.URL, job.Dir)
+			if err := job.SaveReport(batch); err != nil {
+				log.Printf("save report for %s: %v", job.ID, err)
+			}
+			if err := job.Finish(); err != nil {
+				log.Printf("save manifest for %s: %v", job.ID, err)
+			}
+		}(job)
+	}
+}
@@ -2,24 +2,26 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"net/url"
 	"strings"
 
+	"img-scraper/internal"
+
 	"github.com/PuerkitoBio/goquery"
 )
 
 // Scrape fetches the page and returns a list of image and/or video URLs found.
 func Scrape(pageURL string, images, videos bool) ([]string, error) {
-	resp, err := http.Get(pageURL)
+	html, err := internal.FetchStatic(pageURL)
 	if err != nil {
-		return nil, fmt.Errorf("GET error: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("bad status: %s", resp.Status)
+		return nil, err
 	}
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	return scrapeHTML(html, pageURL, images, videos)
+}
+
+// scrapeHTML returns the image and/or video URLs found in already fetched HTML.
+func scrapeHTML(html, pageURL string, images, videos bool) ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
@@ -87,3 +89,134 @@ func Scrape(pageURL string, images, videos bool) ([]string, error) {
 	}
 	return results, nil
 }
+
+// renderedPage is one rendering of the page, under the named emulation profile if any.
+type renderedPage struct {
+	profile string
+	*internal.RenderResult
+}
+
+// renderOutcome is what a job extracts from: one page per emulation profile, the merged
+// candidate URLs, and how the page was fetched.
+type renderOutcome struct {
+	pages    []renderedPage
+	urls     []string
+	mode     string // static or browser
+	reason   string
+	warnings []string
+	// ignored names browser-only options left unused because auto mode stayed static.
+	ignored []string
+}
+
+// fetchCandidates gets the page and its candidate image URLs in the requested render mode.
+// In auto mode the page is fetched statically first and escalates to the browser when
+// nothing is found, the page looks like a JavaScript shell, or something only a render
+// produces was asked for: screenshot, PDF, canvas export, interaction steps or device
+// emulation. Options that only shape a render (wait strategies, network capture, request
+// blocking) apply if the job escalates anyway; otherwise they are listed as ignored. Browser
+// renders happen once per emulation profile.
+func fetchCandidates(pageURL, mode string, opts internal.RenderOptions, profiles []internal.EmulationProfile) (*renderOutcome, error) {
+	if mode == internal.RenderStatic || mode == internal.RenderAuto {
+		out, err := fetchStaticCandidates(pageURL)
+		switch {
+		case mode == internal.RenderStatic:
+			return out, err
+		case err != nil:
+			return fetchBrowserCandidates(pageURL, opts, profiles, fmt.Sprintf("static fetch failed: %v", err))
+		case opts.Screenshot || opts.PDF || opts.CaptureCanvas:
+			return fetchBrowserCandidates(pageURL, opts, profiles, "screenshot/PDF/canvas capture needs the browser")
+		case len(opts.Steps) > 0:
+			return fetchBrowserCandidates(pageURL, opts, profiles, "interaction steps need the browser")
+		case len(profiles) > 0:
+			return fetchBrowserCandidates(pageURL, opts, profiles, "device emulation needs the browser")
+		case internal.LooksLikeJSShell(out.pages[0].HTML):
+			return fetchBrowserCandidates(pageURL, opts, profiles, "page looks like a JavaScript shell")
+		case len(out.urls) == 0:
+			return fetchBrowserCandidates(pageURL, opts, profiles, "static extraction found nothing")
+		}
+		out.reason = "static extraction found candidates"
+		out.ignored = browserOnlyOptions(opts)
+		return out, nil
+	}
+	return fetchBrowserCandidates(pageURL, opts, profiles, "browser mode selected")
+}
+
+// browserOnlyOptions names the options in opts that only matter when the page is rendered.
+func browserOnlyOptions(opts internal.RenderOptions) []string {
+	var names []string
+	if len(opts.Waits) > 0 {
+		names = append(names, "wait strategies")
+	}
+	if opts.CaptureMedia {
+		names = append(names, "network media capture")
+	}
+	if opts.Intercept != nil {
+		names = append(names, "request blocking")
+	}
+	return names
+}
+
+func fetchStaticCandidates(pageURL string) (*renderOutcome, error) {
+	html, err := internal.FetchStatic(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	scraped, err := scrapeHTML(html, pageURL, true, false)
+	if err != nil {
+		return nil, err
+	}
+	extracted, err := internal.ExtractImageURLs(html, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	return &renderOutcome{
+		pages:  []renderedPage{{RenderResult: &internal.RenderResult{HTML: html}}},
+		urls:   mergeCandidates(extracted, scraped, nil),
+		mode:   internal.RenderStatic,
+		reason: "static mode selected",
+	}, nil
+}
+
+// fetchBrowserCandidates renders the page under each profile (or once, with none) and merges
+// the candidates. A profile that fails to render is reported as a warning unless all fail.
+func fetchBrowserCandidates(pageURL string, opts internal.RenderOptions, profiles []internal.EmulationProfile, reason string) (*renderOutcome, error) {
+	if len(profiles) == 0 {
+		profiles = []internal.EmulationProfile{{}}
+	}
+	out := &renderOutcome{mode: internal.RenderBrowser, reason: reason}
+	var extracted, networkURLs []string
+	var media []internal.CapturedMedia
+	var lastErr error
+	for _, p := range profiles {
+		if p.Name != "" {
+			opts.Emulation = &p
+		}
+		page, err := internal.RenderPageWithOptions(pageURL, opts)
+		if err != nil {
+			lastErr = err
+			out.warnings = append(out.warnings, fmt.Sprintf("profile %s: %v", p.Name, err))
+			continue
+		}
+		out.pages = append(out.pages, renderedPage{profile: p.Name, RenderResult: page})
+		urls, err := internal.ExtractImageURLs(page.HTML, pageURL)
+		if err != nil {
+			out.warnings = append(out.warnings, fmt.Sprintf("profile %s: image extraction error: %v", p.Name, err))
+		}
+		extracted = append(extracted, urls...)
+		for _, f := range page.Fragments {
+			fragURLs, err := internal.ExtractImageURLs(f.HTML, f.BaseURL)
+			if err != nil {
+				out.warnings = append(out.warnings, fmt.Sprintf("profile %s: fragment %s: image extraction error: %v", p.Name, f.BaseURL, err))
+				continue
+			}
+			extracted = append(extracted, fragURLs...)
+		}
+		networkURLs = append(networkURLs, internal.CapturedMediaURLs(page.Media)...)
+		media = append(media, page.Media...)
+	}
+	if len(out.pages) == 0 {
+		return nil, lastErr
+	}
+	out.urls = mergeCandidates(extracted, networkURLs, media)
+	return out, nil
+}